@@ -1,7 +1,7 @@
 import axiosInstance from './axiosInstance';
 
 export function login(email: string, password: string) {
-  return axiosInstance.post<{ token: string }>('/auth/login', { email, password });
+  return axiosInstance.post<{ token: string; refresh_token: string }>('/auth/login', { email, password });
 }
 
 export function signup(formData: FormData) {
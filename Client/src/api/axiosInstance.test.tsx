@@ -2,7 +2,7 @@ import { render, screen, waitFor } from '@testing-library/react';
 import { MemoryRouter, Routes, Route, useNavigate } from 'react-router';
 import Profile from '../pages/Profile';
 import { useAuth } from '../context/AuthContext';
-import { setOn401 } from './axiosInstance';
+import axiosInstance, { setOn401 } from './axiosInstance';
 import { http, HttpResponse } from 'msw';
 import { server } from '../test/setup';
 import { vi, expect, it, describe } from 'vitest';
@@ -60,3 +60,34 @@ describe('API-001: axiosInstance 401 error handling', () => {
     expect(screen.getByText('Login Page')).toBeInTheDocument();
   });
 });
+
+describe('API-002: axiosInstance token refresh', () => {
+  it('should refresh an expired access token once and retry the request', async () => {
+    localStorage.setItem('token', 'expired');
+    localStorage.setItem('refresh_token', 'old-refresh');
+    const on401 = vi.fn();
+    setOn401(on401);
+
+    let refreshBody: unknown = null;
+    server.use(
+      http.get('http://localhost:8080/api/users', ({ request }) => {
+        if (request.headers.get('Authorization') !== 'Bearer fresh') {
+          return new HttpResponse(null, { status: 401 });
+        }
+        return HttpResponse.json([]);
+      }),
+      http.post('http://localhost:8080/api/auth/refresh', async ({ request }) => {
+        refreshBody = await request.json();
+        return HttpResponse.json({ token: 'fresh', refresh_token: 'new-refresh' });
+      })
+    );
+
+    const res = await axiosInstance.get('/users');
+
+    expect(res.status).toBe(200);
+    expect(refreshBody).toEqual({ refresh_token: 'old-refresh' });
+    expect(localStorage.getItem('token')).toBe('fresh');
+    expect(localStorage.getItem('refresh_token')).toBe('new-refresh');
+    expect(on401).not.toHaveBeenCalled();
+  });
+});
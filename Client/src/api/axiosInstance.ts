@@ -1,7 +1,9 @@
-import axios from 'axios';
+import axios, { type AxiosError, type InternalAxiosRequestConfig } from 'axios';
+
+const baseURL = 'http://localhost:8080/api';
 
 const instance = axios.create({
-  baseURL: 'http://localhost:8080/api',
+  baseURL,
   //timeout: 5000, // 5 seconds
   headers: {
     'Content-Type': 'application/json',
@@ -28,11 +30,47 @@ instance.interceptors.request.use(
   }
 );
 
-// Add a response interceptor to handle 401 errors
+// Requests failing together share one refresh, a refresh token is only good once
+let refreshing: Promise<string> | null = null;
+
+function refreshAccessToken(): Promise<string> {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem('refresh_token');
+    refreshing = (refreshToken
+      ? axios.post<{ token: string; refresh_token: string }>(`${baseURL}/auth/refresh`, { refresh_token: refreshToken })
+          .then((res) => {
+            localStorage.setItem('token', res.data.token);
+            localStorage.setItem('refresh_token', res.data.refresh_token);
+            return res.data.token;
+          })
+      : Promise.reject(new Error('No refresh token'))
+    ).finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+// Add a response interceptor to handle 401 errors: the access token is short lived,
+// so it is refreshed once and the request retried before logging out
 instance.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error: AxiosError) => {
+    const config = error.config as (InternalAxiosRequestConfig & { _retried?: boolean }) | undefined;
+
     if (error.response && error.response.status === 401) {
+      if (config && !config._retried) {
+        config._retried = true;
+        try {
+          const token = await refreshAccessToken();
+          config.headers.Authorization = `Bearer ${token}`;
+          return instance(config);
+        } catch {
+          // Fall through to logging out
+        }
+      }
+
+      localStorage.removeItem('refresh_token');
       if (on401Callback) {
         on401Callback();
       }
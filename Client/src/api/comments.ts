@@ -8,3 +8,12 @@ export function getComments(recipeId: string) {
 export function postComment(recipeId: string, comment: string, rating: number) {
   return axiosInstance.post(`/comments/post/${recipeId}`, { comment, rating });
 }
+
+// Live comments of a recipe over Server-Sent Events, returns a function that closes the stream
+export function streamComments(recipeId: string, onComment: (comment: Comment) => void, onResync: () => void) {
+  const source = new EventSource(`${axiosInstance.defaults.baseURL}/stream?recipe=${recipeId}`);
+  source.addEventListener('comment', (e) => onComment(JSON.parse((e as MessageEvent).data)));
+  // Sent when the server couldn't replay what was missed while reconnecting
+  source.addEventListener('resync', onResync);
+  return () => source.close();
+}
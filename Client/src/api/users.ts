@@ -7,6 +7,7 @@ interface UserRecipeRaw {
   description: string;
   meal_type_id: string;
   img_url: string;
+  img_card_url?: string;
   rating: string;
 }
 
@@ -18,6 +19,7 @@ export async function getUserRecipes(): Promise<Recipe[]> {
     description: r.description,
     meal_type_id: Number(r.meal_type_id),
     image: r.img_url,
+    img_card_url: r.img_card_url,
     rating: Number(r.rating),
   }));
 }
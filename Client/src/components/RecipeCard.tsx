@@ -12,7 +12,7 @@ const RecipeCard: React.FC<RecipeCardProps> = ({ recipe }) => {
       className="bg-white shadow-md rounded-2xl overflow-hidden grid grid-cols-1 md:grid-cols-3"
     >
       <img
-        src={recipe.image}
+        src={recipe.img_card_url || recipe.image}
         alt={recipe.name_recipe}
         className="object-cover w-full h-full md:col-span-1"
       />
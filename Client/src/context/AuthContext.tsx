@@ -22,6 +22,7 @@ export function AuthProvider({ children }: AuthProviderProps){
 
   const logout = useCallback(() => {
     localStorage.removeItem("token")
+    localStorage.removeItem("refresh_token")
     setUser(null)
   }, [])
 
@@ -28,6 +28,7 @@ const Login: React.FC = () => {
 
       if(res.data.token){
         localStorage.setItem("token", res.data.token)
+        localStorage.setItem("refresh_token", res.data.refresh_token)
       }
 
       //refresing the page
@@ -4,7 +4,7 @@ import axios from 'axios';
 import { useParams } from 'react-router';
 import type { RecipeDetail, Comment } from '../types';
 import { getRecipeById } from '../api/recipes';
-import { getComments, postComment } from '../api/comments';
+import { getComments, postComment, streamComments } from '../api/comments';
 
 const RecipeDetails: React.FC = () => {
   const [recipe, setRecipe] = useState<RecipeDetail | null>(null);
@@ -44,6 +44,15 @@ const RecipeDetails: React.FC = () => {
     fetchData();
   }, [id]);
 
+  // New comments from other users show up without a refresh
+  useEffect(() => {
+    return streamComments(
+      id!,
+      (comment) => setComments(prev => prev.some(c => c.id === comment.id) ? prev : [...prev, comment]),
+      fetchComments,
+    );
+  }, [id]);
+
   const handleChange = (e: React.ChangeEvent<HTMLInputElement | HTMLTextAreaElement>) => {
     const { name, value } = e.target;
     setNewComment(prev => ({
@@ -92,7 +101,7 @@ const RecipeDetails: React.FC = () => {
         </p>
         <img
           //src="https://i.pravatar.cc/150?u=a042581f4e29026024d"
-          src={recipe.img_url}
+          src={recipe.img_detail_url || recipe.img_url}
           alt={recipe.name_recipe}
           className="w-full max-h-96 object-cover rounded-lg shadow"
         />
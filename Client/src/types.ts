@@ -10,11 +10,13 @@ export interface Recipe {
   description: string;
   meal_type_id: number;
   image: string;
+  img_card_url?: string;
   rating: number;
 }
 
 export interface RecipeDetail extends Recipe {
   img_url: string;
+  img_detail_url?: string;
   creator_name: string;
   steps: string[];
 }
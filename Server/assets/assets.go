@@ -0,0 +1,48 @@
+// Package assets records every image written to the image store and deletes the ones
+// nothing references anymore, such as a replaced recipe photo or the avatar of a purged account.
+package assets
+
+import (
+	"context"
+	"io"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/logging"
+)
+
+type ownerKey struct{}
+
+// WithOwner marks the uploads made with ctx as belonging to the user
+func WithOwner(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, userID)
+}
+
+// Store wraps an ImageStore and records each saved image in the assets table
+type Store struct {
+	lib.ImageStore
+	DB db.DBExecutor
+}
+
+func NewStore(db db.DBExecutor, store lib.ImageStore) *Store {
+	return &Store{ImageStore: store, DB: db}
+}
+
+func (s *Store) Save(ctx context.Context, file io.Reader, filename string) (string, error) {
+	url, err := s.ImageStore.Save(ctx, file, filename)
+	if err != nil {
+		return "", err
+	}
+
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	_, err = s.DB.ExecContext(ctx,
+		"INSERT INTO assets (url, owner_id) VALUES ($1, NULLIF($2, '')::integer) ON CONFLICT (url) DO NOTHING",
+		url, owner,
+	)
+	if err != nil {
+		// An unrecorded image is only never cleaned up, the upload itself succeeded
+		logging.FromContext(ctx).Error("Asset record error", "err", err)
+	}
+
+	return url, nil
+}
@@ -0,0 +1,168 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
+)
+
+func newLocalStore(t *testing.T) *lib.LocalStore {
+	t.Helper()
+	store, err := lib.NewLocalStore(t.TempDir(), "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return store
+}
+
+func TestStore_RecordsOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO assets (url, owner_id)")).
+		WithArgs(sqlmock.AnyArg(), "5").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	store := NewStore(db, newLocalStore(t))
+
+	url, err := store.Save(WithOwner(context.Background(), "5"), strings.NewReader("fake image"), "photo.jpg")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if !strings.HasPrefix(url, "http://localhost:8080/media/") {
+		t.Errorf("unexpected URL %q", url)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCollector_DeletesOrphans(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	local := newLocalStore(t)
+	url, err := local.Save(context.Background(), strings.NewReader("old photo"), "old.jpg")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT a.id, a.url FROM assets a")).
+		WithArgs(DefaultGrace.Seconds(), BatchSize, RetryBackoff.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url"}).AddRow("1", url))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM assets WHERE id = $1")).
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := NewCollector(db, local).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(local.Dir, filepath.Base(url))); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be deleted, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCollector_DryRunKeepsFiles(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	local := newLocalStore(t)
+	url, err := local.Save(context.Background(), strings.NewReader("old photo"), "old.jpg")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT a.id, a.url FROM assets a")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url"}).AddRow("1", url))
+
+	collector := NewCollector(db, local)
+	collector.DryRun = true
+	if err := collector.Collect(context.Background()); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(local.Dir, filepath.Base(url))); err != nil {
+		t.Errorf("Expected the file to be kept, got %v", err)
+	}
+
+	// No DELETE was issued
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+// failingStore can't delete anything
+type failingStore struct{ lib.ImageStore }
+
+func (failingStore) Delete(ctx context.Context, url string) error {
+	return errors.New("store unreachable")
+}
+
+func TestCollector_FailedDeleteIsCounted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT a.id, a.url FROM assets a")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url"}).AddRow("1", "http://localhost:8080/media/old.jpg"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE assets SET attempts = attempts + 1, last_attempt_at = NOW() WHERE id = $1")).
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := NewCollector(db, failingStore{}).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	// The record is kept for a later run
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCollector_DropsForeignURL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	// Left over from another image store, the local one can never delete it
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT a.id, a.url FROM assets a")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url"}).AddRow("1", "https://res.cloudinary.com/demo/image/upload/old.jpg"))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM assets WHERE id = $1")).
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := NewCollector(db, newLocalStore(t)).Collect(context.Background()); err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/lib"
+)
+
+const (
+	// DefaultGrace leaves time for the request that uploaded an image to save its URL
+	DefaultGrace = 24 * time.Hour
+	// BatchSize caps the deletions of a single run
+	BatchSize = 500
+	// RetryBackoff is how long an asset waits after each failed delete before it is tried again
+	RetryBackoff = time.Hour
+)
+
+// Orphan is a recorded asset that no row points to
+type Orphan struct {
+	ID  string
+	URL string
+}
+
+// Every column that holds an image URL, an asset is in use while one of them matches it.
+// Assets that failed to delete wait longer after each failure and come after the others,
+// so a few stuck ones can't fill every batch.
+const orphans = `
+	SELECT a.id, a.url FROM assets a
+	WHERE a.created_at < NOW() - make_interval(secs => $1)
+		AND (a.last_attempt_at IS NULL OR a.last_attempt_at < NOW() - make_interval(secs => $3 * a.attempts))
+		AND NOT EXISTS (SELECT 1 FROM recipes r WHERE a.url IN (r.img_url, r.img_card_url, r.img_detail_url))
+		AND NOT EXISTS (SELECT 1 FROM recipe_images i WHERE a.url IN (i.url, i.card_url))
+		AND NOT EXISTS (SELECT 1 FROM users u WHERE u.url_photo = a.url)
+	ORDER BY a.attempts, a.id
+	LIMIT $2`
+
+// Collector deletes orphaned assets older than Grace. With DryRun it only logs what it would delete.
+type Collector struct {
+	DB     db.DBExecutor
+	Store  lib.ImageStore
+	Grace  time.Duration
+	DryRun bool
+}
+
+func NewCollector(db db.DBExecutor, store lib.ImageStore) *Collector {
+	return &Collector{DB: db, Store: store, Grace: DefaultGrace}
+}
+
+// Orphans lists up to BatchSize assets that can be deleted
+func (c *Collector) Orphans(ctx context.Context) ([]Orphan, error) {
+	rows, err := c.DB.QueryContext(ctx, orphans, c.Grace.Seconds(), BatchSize, RetryBackoff.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Orphan
+	for rows.Next() {
+		var o Orphan
+		if err := rows.Scan(&o.ID, &o.URL); err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+	}
+
+	return list, rows.Err()
+}
+
+// Collect is the cleanup job. An image that fails to delete keeps its record and is retried
+// after RetryBackoff, one the store never handed out only loses its record.
+func (c *Collector) Collect(ctx context.Context) error {
+	list, err := c.Orphans(ctx)
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		for _, o := range list {
+			slog.Info("Asset cleanup (dry run) would delete", "url", o.URL)
+		}
+		if len(list) > 0 {
+			slog.Info("Asset cleanup (dry run) found orphaned images", "count", len(list))
+		}
+		return nil
+	}
+
+	deleted := 0
+	for _, o := range list {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := c.Store.Delete(ctx, o.URL)
+		if errors.Is(err, lib.ErrNotStored) {
+			slog.Warn("Asset not held by the image store, dropping its record", "url", o.URL)
+		} else if err != nil {
+			slog.Error("Asset delete error", "url", o.URL, "err", err)
+			if _, err := c.DB.ExecContext(ctx, "UPDATE assets SET attempts = attempts + 1, last_attempt_at = NOW() WHERE id = $1", o.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := c.DB.ExecContext(ctx, "DELETE FROM assets WHERE id = $1", o.ID); err != nil {
+			return err
+		}
+		deleted++
+	}
+
+	if deleted > 0 {
+		slog.Info("Asset cleanup deleted orphaned images", "count", deleted)
+	}
+
+	return nil
+}
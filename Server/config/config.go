@@ -0,0 +1,489 @@
+// Package config loads the server settings once at startup. Values come from, in increasing
+// priority, the defaults below, an optional YAML file named by CONFIG_FILE, and the environment,
+// which a .env file in the working directory can fill in.
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Development = "development"
+	Production  = "production"
+
+	// MinSecretLength is enforced in production, the key signs every token
+	MinSecretLength = 32
+)
+
+type Config struct {
+	// Env is development or production, set with APP_ENV
+	Env       string `yaml:"env"`
+	SecretKey string `yaml:"secret_key"`
+	// AppURL is where the client runs, links in emails point there
+	AppURL    string `yaml:"app_url"`
+	ExportDir string `yaml:"export_dir"`
+
+	Server   Server   `yaml:"server"`
+	Database Database `yaml:"database"`
+	Images   Images   `yaml:"images"`
+	Mail     Mail     `yaml:"mail"`
+	Google   Google   `yaml:"google"`
+	Assets   Assets   `yaml:"assets"`
+	Log      Log      `yaml:"log"`
+	Tracing  Tracing  `yaml:"tracing"`
+	// MetricsToken, when set, has to be sent as a bearer token to read /metrics
+	MetricsToken string `yaml:"metrics_token"`
+
+	// Warnings are found while loading, to be logged once the logger is set up
+	Warnings []string `yaml:"-"`
+}
+
+// Server is the HTTP listener. HTTPS is served when both TLS files are set.
+type Server struct {
+	Addr              string        `yaml:"addr"`
+	TLSCertFile       string        `yaml:"tls_cert_file"`
+	TLSKeyFile        string        `yaml:"tls_key_file"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout is how long in-flight requests get to finish on SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// TrustedProxies are the addresses or CIDRs of the reverse proxies in front of the server,
+	// X-Forwarded-For is only believed from them
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type Database struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+}
+
+// Images picks the image store. Without a backend Cloudinary is used when its cloud is set, local files otherwise.
+type Images struct {
+	Backend      string     `yaml:"backend"`
+	MediaDir     string     `yaml:"media_dir"`
+	MediaBaseURL string     `yaml:"media_base_url"`
+	Cloudinary   Cloudinary `yaml:"cloudinary"`
+	S3           S3         `yaml:"s3"`
+}
+
+// Store is the backend in use, Backend or the one picked without it
+func (i Images) Store() string {
+	if i.Backend != "" {
+		return i.Backend
+	}
+	if i.Cloudinary.Cloud != "" {
+		return "cloudinary"
+	}
+	return "local"
+}
+
+type Cloudinary struct {
+	Cloud     string `yaml:"cloud"`
+	APIKey    string `yaml:"api_key"`
+	APISecret string `yaml:"api_secret"`
+}
+
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	UseSSL    bool   `yaml:"use_ssl"`
+	PublicURL string `yaml:"public_url"`
+}
+
+// Mail goes through SMTP when SMTPHost is set, otherwise to LogFile or stdout. Production needs SMTP.
+type Mail struct {
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     string `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	From         string `yaml:"from"`
+	LogFile      string `yaml:"log_file"`
+}
+
+// Google login is enabled by a client ID
+type Google struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	Issuer       string `yaml:"issuer"`
+	RedirectURL  string `yaml:"redirect_url"`
+}
+
+// Log is json for collectors or text for reading in a terminal, at debug, info, warn or error level
+type Log struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// Tracing sends OpenTelemetry spans to an OTLP collector, or prints them with the stdout exporter.
+// The collector endpoint and headers come from the standard OTEL_EXPORTER_OTLP_* variables.
+type Tracing struct {
+	// Exporter is none, stdout or otlp, nothing is recorded with none
+	Exporter    string  `yaml:"exporter"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type Assets struct {
+	GCGrace  time.Duration `yaml:"gc_grace"`
+	GCDryRun bool          `yaml:"gc_dry_run"`
+}
+
+func defaults() Config {
+	return Config{
+		Env:       Development,
+		AppURL:    "http://localhost:5173",
+		ExportDir: "exports",
+		Server: Server{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute,
+			WriteTimeout:      2 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Database: Database{
+			Host:    "localhost",
+			Port:    "5432",
+			User:    "postgres",
+			Name:    "bitebox",
+			SSLMode: "disable",
+		},
+		Images: Images{
+			MediaDir:     "media",
+			MediaBaseURL: "http://localhost:8080/media",
+			S3:           S3{UseSSL: true},
+		},
+		Mail: Mail{
+			SMTPPort: "587",
+			From:     "BiteBox <no-reply@bitebox.local>",
+		},
+		Google: Google{
+			Issuer:      "https://accounts.google.com",
+			RedirectURL: "http://localhost:8080/api/auth/google/callback",
+		},
+		Assets: Assets{GCGrace: 24 * time.Hour},
+		Log:    Log{Format: "json", Level: "info"},
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "bitebox",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load reads and validates the configuration, the server should not start on an error
+func Load() (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+
+	cfg := defaults()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	env := envReader{errs: &errs}
+
+	env.str(&cfg.Env, "APP_ENV")
+	env.str(&cfg.SecretKey, "SECRET_KEY")
+	env.str(&cfg.AppURL, "APP_URL")
+	env.str(&cfg.ExportDir, "EXPORT_DIR")
+
+	env.str(&cfg.Server.Addr, "SERVER_ADDR")
+	env.str(&cfg.Server.TLSCertFile, "TLS_CERT_FILE")
+	env.str(&cfg.Server.TLSKeyFile, "TLS_KEY_FILE")
+	env.duration(&cfg.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT")
+	env.duration(&cfg.Server.ReadTimeout, "SERVER_READ_TIMEOUT")
+	env.duration(&cfg.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT")
+	env.duration(&cfg.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT")
+	env.duration(&cfg.Server.ShutdownTimeout, "SERVER_SHUTDOWN_TIMEOUT")
+	env.list(&cfg.Server.TrustedProxies, "TRUSTED_PROXIES")
+
+	env.str(&cfg.Database.Host, "DB_HOST")
+	env.str(&cfg.Database.Port, "DB_PORT")
+	env.str(&cfg.Database.User, "DB_USER")
+	env.str(&cfg.Database.Password, "DB_PASSWORD")
+	env.str(&cfg.Database.Name, "DB_NAME")
+	env.str(&cfg.Database.SSLMode, "DB_SSLMODE")
+
+	env.str(&cfg.Images.Backend, "IMAGE_STORE")
+	env.str(&cfg.Images.MediaDir, "MEDIA_DIR")
+	env.str(&cfg.Images.MediaBaseURL, "MEDIA_BASE_URL")
+	env.str(&cfg.Images.Cloudinary.Cloud, "CLOUDINARY_CLOUD")
+	env.str(&cfg.Images.Cloudinary.APIKey, "CLOUDINARY_API_KEY")
+	env.str(&cfg.Images.Cloudinary.APISecret, "CLOUDINARY_API_SECRET")
+	env.str(&cfg.Images.S3.Endpoint, "S3_ENDPOINT")
+	env.str(&cfg.Images.S3.Region, "S3_REGION")
+	env.str(&cfg.Images.S3.Bucket, "S3_BUCKET")
+	env.str(&cfg.Images.S3.AccessKey, "S3_ACCESS_KEY")
+	env.str(&cfg.Images.S3.SecretKey, "S3_SECRET_KEY")
+	env.bool(&cfg.Images.S3.UseSSL, "S3_USE_SSL")
+	env.str(&cfg.Images.S3.PublicURL, "S3_PUBLIC_URL")
+
+	env.str(&cfg.Mail.SMTPHost, "SMTP_HOST")
+	env.str(&cfg.Mail.SMTPPort, "SMTP_PORT")
+	env.str(&cfg.Mail.SMTPUsername, "SMTP_USERNAME")
+	env.str(&cfg.Mail.SMTPPassword, "SMTP_PASSWORD")
+	env.str(&cfg.Mail.From, "MAIL_FROM")
+	env.str(&cfg.Mail.LogFile, "MAIL_LOG_FILE")
+
+	env.str(&cfg.Google.ClientID, "GOOGLE_CLIENT_ID")
+	env.str(&cfg.Google.ClientSecret, "GOOGLE_CLIENT_SECRET")
+	env.str(&cfg.Google.Issuer, "GOOGLE_ISSUER")
+	env.str(&cfg.Google.RedirectURL, "GOOGLE_REDIRECT_URL")
+
+	env.duration(&cfg.Assets.GCGrace, "ASSET_GC_GRACE")
+	env.bool(&cfg.Assets.GCDryRun, "ASSET_GC_DRY_RUN")
+
+	env.str(&cfg.MetricsToken, "METRICS_TOKEN")
+
+	env.str(&cfg.Log.Format, "LOG_FORMAT")
+	env.str(&cfg.Log.Level, "LOG_LEVEL")
+
+	env.str(&cfg.Tracing.Exporter, "TRACING_EXPORTER")
+	env.str(&cfg.Tracing.ServiceName, "OTEL_SERVICE_NAME")
+	env.float(&cfg.Tracing.SampleRatio, "TRACING_SAMPLE_RATIO")
+
+	if err := errors.Join(append(errs, cfg.validate())...); err != nil {
+		return nil, err
+	}
+
+	if cfg.SecretKey == "" {
+		// Development only, validate refuses this in production. Tokens do not survive a restart.
+		cfg.Warnings = append(cfg.Warnings, "SECRET_KEY is not set, using a random key for this run")
+		cfg.SecretKey = randomKey()
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+
+	switch c.Env {
+	case Development:
+	case Production:
+		if len(c.SecretKey) < MinSecretLength {
+			errs = append(errs, fmt.Errorf("SECRET_KEY must be set to at least %d characters in production", MinSecretLength))
+		}
+		if c.Database.Password == "" {
+			errs = append(errs, errors.New("DB_PASSWORD must be set in production"))
+		}
+		// Without it verification and reset mails would only reach the server's log
+		if c.Mail.SMTPHost == "" {
+			errs = append(errs, errors.New("SMTP_HOST must be set in production"))
+		}
+		// Missing credentials would otherwise only show when the first upload fails
+		switch c.Images.Store() {
+		case "cloudinary":
+			if c.Images.Cloudinary.Cloud == "" || c.Images.Cloudinary.APIKey == "" || c.Images.Cloudinary.APISecret == "" {
+				errs = append(errs, errors.New("CLOUDINARY_CLOUD, CLOUDINARY_API_KEY and CLOUDINARY_API_SECRET must be set in production"))
+			}
+		case "s3":
+			if c.Images.S3.AccessKey == "" || c.Images.S3.SecretKey == "" {
+				errs = append(errs, errors.New("S3_ACCESS_KEY and S3_SECRET_KEY must be set in production"))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("APP_ENV must be %s or %s, got %q", Development, Production, c.Env))
+	}
+
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+
+	switch c.Images.Backend {
+	case "", "local", "cloudinary":
+	case "s3":
+		if c.Images.S3.Endpoint == "" || c.Images.S3.Bucket == "" {
+			errs = append(errs, errors.New("S3_ENDPOINT and S3_BUCKET are required with IMAGE_STORE=s3"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown IMAGE_STORE %q, use cloudinary, local or s3", c.Images.Backend))
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SERVER_SHUTDOWN_TIMEOUT must be positive"))
+	}
+	if _, err := c.Server.TrustedProxyPrefixes(); err != nil {
+		errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %w", err))
+	}
+
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be json or text, got %q", c.Log.Format))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", c.Log.Level))
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER must be none, stdout or otlp, got %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", c.Tracing.SampleRatio))
+	}
+
+	if c.Assets.GCGrace < 0 {
+		errs = append(errs, errors.New("ASSET_GC_GRACE must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// TrustedProxyPrefixes parses TrustedProxies, a plain address stands for itself alone
+func (s Server) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an address or CIDR", proxy)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or CIDR", proxy)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// TLS reports whether the server listens with HTTPS
+func (c *Config) TLS() bool {
+	return c.Server.TLSCertFile != ""
+}
+
+// Production reports whether the server runs in production mode
+func (c *Config) Production() bool {
+	return c.Env == Production
+}
+
+// Redacted is the effective configuration with every secret masked, keyed as in the YAML file,
+// for the startup log
+func (c *Config) Redacted() map[string]any {
+	r := *c
+	for _, secret := range []*string{
+		&r.SecretKey,
+		&r.Database.Password,
+		&r.Images.Cloudinary.APISecret,
+		&r.Images.S3.SecretKey,
+		&r.Mail.SMTPPassword,
+		&r.Google.ClientSecret,
+		&r.MetricsToken,
+	} {
+		if *secret != "" {
+			*secret = "[redacted]"
+		}
+	}
+
+	// The YAML round trip keeps the file's keys and writes durations as 24h0m0s
+	var out map[string]any
+	data, err := yaml.Marshal(r)
+	if err == nil {
+		err = yaml.Unmarshal(data, &out)
+	}
+	if err != nil {
+		return map[string]any{"error": err.Error()}
+	}
+	return out
+}
+
+// envReader overrides a setting when its variable is set and collects the values that do not parse
+type envReader struct {
+	errs *[]error
+}
+
+func (e envReader) str(dst *string, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		*dst = value
+	}
+}
+
+// list reads a comma separated value, blank entries are dropped
+func (e envReader) list(dst *[]string, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+	}
+}
+
+func (e envReader) bool(dst *bool, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			*e.errs = append(*e.errs, fmt.Errorf("%s: %q is not a boolean", key, value))
+			return
+		}
+		*dst = b
+	}
+}
+
+func (e envReader) duration(dst *time.Duration, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			*e.errs = append(*e.errs, fmt.Errorf("%s: %q is not a duration such as 24h", key, value))
+			return
+		}
+		*dst = d
+	}
+}
+
+func (e envReader) float(dst *float64, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			*e.errs = append(*e.errs, fmt.Errorf("%s: %q is not a number", key, value))
+			return
+		}
+		*dst = f
+	}
+}
+
+func randomKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad_FileThenEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bitebox.yaml")
+	err := os.WriteFile(path, []byte("secret_key: from-file\ndatabase:\n  host: db.internal\n  port: \"6432\"\nassets:\n  gc_grace: 2h\n"), 0o600)
+	if err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DB_HOST", "db.override")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.SecretKey != "from-file" || cfg.Database.Port != "6432" || cfg.Assets.GCGrace != 2*time.Hour {
+		t.Errorf("Expected the file's values, got %+v", cfg)
+	}
+	if cfg.Database.Host != "db.override" {
+		t.Errorf("Expected the environment to win over the file, got %q", cfg.Database.Host)
+	}
+	if cfg.Database.Name != "bitebox" {
+		t.Errorf("Expected defaults for unset values, got %q", cfg.Database.Name)
+	}
+}
+
+func TestLoad_ProductionRequiresSecrets(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("SECRET_KEY", "other_key")
+	t.Setenv("ASSET_GC_GRACE", "soon")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Expected production to refuse a weak SECRET_KEY")
+	}
+
+	for _, want := range []string{"SECRET_KEY", "DB_PASSWORD", "SMTP_HOST", "ASSET_GC_GRACE"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected every problem reported, %s missing from %q", want, err)
+		}
+	}
+}
+
+func TestLoad_DevelopmentGeneratesSecret(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if len(cfg.SecretKey) < MinSecretLength || cfg.SecretKey == "other_key" {
+		t.Errorf("Expected a random secret, got %q", cfg.SecretKey)
+	}
+}
+
+func TestRedacted_HidesSecrets(t *testing.T) {
+	cfg := defaults()
+	cfg.SecretKey = "super-secret-signing-key"
+	cfg.Database.Password = "hunter2"
+	cfg.Mail.SMTPPassword = "smtp-pass"
+
+	out := fmt.Sprint(cfg.Redacted())
+
+	for _, secret := range []string{"super-secret-signing-key", "hunter2", "smtp-pass"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Secret %q printed in %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "host:localhost") {
+		t.Errorf("Expected the other settings printed, got %s", out)
+	}
+	if cfg.SecretKey != "super-secret-signing-key" {
+		t.Error("Redacted must not change the configuration")
+	}
+}
+
+func TestLoad_TLSNeedsBothFiles(t *testing.T) {
+	t.Setenv("TLS_CERT_FILE", "cert.pem")
+
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "TLS_KEY_FILE") {
+		t.Errorf("Expected the missing key file reported, got %v", err)
+	}
+}
+
+func TestLoad_TracingSettings(t *testing.T) {
+	t.Setenv("TRACING_EXPORTER", "jaeger")
+	t.Setenv("TRACING_SAMPLE_RATIO", "half")
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Expected an unknown exporter to be refused")
+	}
+	for _, want := range []string{"TRACING_EXPORTER", "TRACING_SAMPLE_RATIO"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %s reported, got %q", want, err)
+		}
+	}
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.7")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	prefixes, err := cfg.Server.TrustedProxyPrefixes()
+	if err != nil {
+		t.Fatalf("TrustedProxyPrefixes failed: %v", err)
+	}
+	if len(prefixes) != 2 || prefixes[0].String() != "10.0.0.0/8" || prefixes[1].String() != "192.0.2.7/32" {
+		t.Errorf("Expected both proxies parsed, got %v", prefixes)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "TRUSTED_PROXIES") {
+		t.Errorf("Expected a bad proxy address refused, got %v", err)
+	}
+}
+
+func TestLoad_ProductionRequiresImageCredentials(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("SECRET_KEY", strings.Repeat("k", MinSecretLength))
+	t.Setenv("DB_PASSWORD", "hunter2")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+
+	// Cloudinary is picked from its cloud alone
+	t.Setenv("CLOUDINARY_CLOUD", "demo")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "CLOUDINARY_API_KEY") {
+		t.Errorf("Expected the Cloudinary key required, got %v", err)
+	}
+
+	t.Setenv("IMAGE_STORE", "s3")
+	t.Setenv("S3_ENDPOINT", "s3.example.com")
+	t.Setenv("S3_BUCKET", "bitebox")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "S3_ACCESS_KEY") {
+		t.Errorf("Expected the S3 keys required, got %v", err)
+	}
+
+	t.Setenv("S3_ACCESS_KEY", "access")
+	t.Setenv("S3_SECRET_KEY", "secret")
+	if _, err := Load(); err != nil {
+		t.Errorf("Expected a complete production config to load, got %v", err)
+	}
+}
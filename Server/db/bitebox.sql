@@ -29,7 +29,8 @@ CREATE TABLE public.comments (
     user_id integer,
     recipe_id integer,
     comment text,
-    rating double precision
+    rating double precision,
+    is_hidden boolean DEFAULT false NOT NULL
 );
 
 
@@ -102,7 +103,12 @@ CREATE TABLE public.recipes (
     description text,
     meal_type_id integer,
     img_url character varying,
-    guest_name character varying(100)
+    img_card_url character varying,
+    img_detail_url character varying,
+    guest_name character varying(100),
+    steps text,
+    is_active boolean DEFAULT true NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
 );
 
 
@@ -138,9 +144,17 @@ CREATE TABLE public.users (
     id integer NOT NULL,
     name character varying(100),
     email text,
-    password character varying(12),
+    password character varying(100),
     url_photo character varying,
-    google_id integer
+    bio character varying(500),
+    role character varying(20) DEFAULT 'user'::character varying NOT NULL,
+    is_suspended boolean DEFAULT false NOT NULL,
+    email_verified boolean DEFAULT false NOT NULL,
+    totp_secret character varying(64),
+    totp_enabled boolean DEFAULT false NOT NULL,
+    totp_last_step bigint,
+    deletion_scheduled_at timestamp with time zone,
+    CONSTRAINT users_role_check CHECK (((role)::text = ANY ((ARRAY['user'::character varying, 'moderator'::character varying, 'admin'::character varying])::text[])))
 );
 
 
@@ -234,9 +248,9 @@ COPY public.recipes (id, user_id, name_recipe, description, meal_type_id, img_ur
 -- Data for Name: users; Type: TABLE DATA; Schema: public; Owner: postgres
 --
 
-COPY public.users (id, name, email, password, url_photo, google_id) FROM stdin;
-1	Jane Doe	janedoe@gmail.com	123456789	\N	\N
-2	John Doe	johndoe@gmail.com	123456789	\N	\N
+COPY public.users (id, name, email, password, url_photo, role) FROM stdin;
+1	Jane Doe	janedoe@gmail.com	123456789	\N	admin
+2	John Doe	johndoe@gmail.com	123456789	\N	user
 \.
 
 
@@ -300,6 +314,13 @@ ALTER TABLE ONLY public.users
     ADD CONSTRAINT users_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: users_email_lower_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX users_email_lower_idx ON public.users USING btree (lower(email));
+
+
 --
 -- Name: comments comments_recipe_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -332,6 +353,295 @@ ALTER TABLE ONLY public.recipes
     ADD CONSTRAINT recipes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
 
 
+--
+-- Name: reports; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.reports (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    reporter_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    target_type character varying(20) NOT NULL CHECK (target_type IN ('recipe', 'comment')),
+    target_id integer NOT NULL,
+    reason character varying(30) NOT NULL CHECK (reason IN ('spam', 'offensive', 'inappropriate', 'copyright', 'other')),
+    details text,
+    status character varying(20) DEFAULT 'open' NOT NULL CHECK (status IN ('open', 'dismissed', 'actioned')),
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    resolved_at timestamp with time zone,
+    resolved_by integer REFERENCES public.users(id) ON DELETE SET NULL
+);
+
+
+ALTER TABLE public.reports OWNER TO postgres;
+
+CREATE UNIQUE INDEX reports_open_unique ON public.reports (reporter_id, target_type, target_id) WHERE status = 'open';
+
+CREATE INDEX reports_status_idx ON public.reports (status, created_at);
+
+
+--
+-- Name: moderation_actions; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.moderation_actions (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    moderator_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    report_id integer REFERENCES public.reports(id) ON DELETE SET NULL,
+    action character varying(30) NOT NULL,
+    target_type character varying(20) NOT NULL,
+    target_id integer NOT NULL,
+    target_user_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    note text,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.moderation_actions OWNER TO postgres;
+
+
+--
+-- Name: sessions; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.sessions (
+    id character varying(64) PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    user_agent text,
+    ip character varying(64),
+    device character varying(100),
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    last_seen_at timestamp with time zone DEFAULT now() NOT NULL,
+    revoked_at timestamp with time zone
+);
+
+
+ALTER TABLE public.sessions OWNER TO postgres;
+
+CREATE INDEX sessions_user_id_idx ON public.sessions (user_id);
+
+
+--
+-- Name: refresh_tokens; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.refresh_tokens (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    session_id character varying(64) NOT NULL REFERENCES public.sessions(id) ON DELETE CASCADE,
+    token_hash character varying(64) NOT NULL UNIQUE,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.refresh_tokens OWNER TO postgres;
+
+
+--
+-- Name: user_tokens; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.user_tokens (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    purpose character varying(30) NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+    token_hash character varying(64) NOT NULL UNIQUE,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.user_tokens OWNER TO postgres;
+
+
+--
+-- Name: identities; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.identities (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    provider character varying(50) NOT NULL,
+    subject character varying(255) NOT NULL,
+    email text,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+
+ALTER TABLE public.identities OWNER TO postgres;
+
+
+--
+-- Name: recovery_codes; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.recovery_codes (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    code_hash character varying(64) NOT NULL,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    UNIQUE (user_id, code_hash)
+);
+
+
+ALTER TABLE public.recovery_codes OWNER TO postgres;
+
+
+--
+-- Name: login_failures; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.login_failures (
+    key character varying(320) PRIMARY KEY,
+    failures integer DEFAULT 0 NOT NULL,
+    last_failure_at timestamp with time zone NOT NULL,
+    locked_until timestamp with time zone
+);
+
+
+ALTER TABLE public.login_failures OWNER TO postgres;
+
+
+--
+-- Name: mfa_challenges; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.mfa_challenges (
+    id character varying(64) PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    attempts integer DEFAULT 0 NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
+
+
+ALTER TABLE public.mfa_challenges OWNER TO postgres;
+
+
+--
+-- Name: data_exports; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.data_exports (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    status character varying(20) DEFAULT 'pending' NOT NULL CHECK (status IN ('pending', 'processing', 'ready', 'failed', 'expired')),
+    file_path text,
+    error text,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    started_at timestamp with time zone,
+    completed_at timestamp with time zone,
+    expires_at timestamp with time zone
+);
+
+
+ALTER TABLE public.data_exports OWNER TO postgres;
+
+CREATE INDEX data_exports_status_idx ON public.data_exports USING btree (status, created_at);
+
+
+--
+-- Name: follows; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.follows (
+    follower_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    followee_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+
+ALTER TABLE public.follows OWNER TO postgres;
+
+CREATE INDEX follows_followee_idx ON public.follows USING btree (followee_id);
+
+CREATE INDEX recipes_user_created_idx ON public.recipes USING btree (user_id, created_at DESC, id DESC);
+
+
+--
+-- Name: notifications; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.notifications (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    type character varying(40) NOT NULL,
+    actor_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    target_type character varying(20) NOT NULL,
+    target_id integer NOT NULL,
+    data jsonb DEFAULT '{}'::jsonb NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    read_at timestamp with time zone
+);
+
+
+ALTER TABLE public.notifications OWNER TO postgres;
+
+CREATE INDEX notifications_user_idx ON public.notifications USING btree (user_id, id DESC);
+
+
+--
+-- Name: recipe_images; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.recipe_images (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    recipe_id integer NOT NULL REFERENCES public.recipes(id) ON DELETE CASCADE,
+    url character varying NOT NULL,
+    card_url character varying NOT NULL,
+    caption character varying(200) DEFAULT ''::character varying NOT NULL,
+    "position" integer DEFAULT 0 NOT NULL,
+    is_cover boolean DEFAULT false NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.recipe_images OWNER TO postgres;
+
+CREATE INDEX recipe_images_recipe_idx ON public.recipe_images USING btree (recipe_id, "position", id);
+
+-- One cover per recipe, its URLs are copied to recipes.img_url for the list endpoints
+CREATE UNIQUE INDEX recipe_images_cover_idx ON public.recipe_images USING btree (recipe_id) WHERE is_cover;
+
+
+--
+-- Name: assets; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.assets (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    url character varying NOT NULL UNIQUE,
+    owner_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_attempt_at timestamp with time zone
+);
+
+
+ALTER TABLE public.assets OWNER TO postgres;
+
+CREATE INDEX assets_created_idx ON public.assets USING btree (created_at);
+
+
+--
+-- Name: schema_migrations; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.schema_migrations (
+    version integer PRIMARY KEY,
+    applied_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+ALTER TABLE public.schema_migrations OWNER TO postgres;
+
+-- Keep in step with db.SchemaVersion
+INSERT INTO public.schema_migrations (version) VALUES (1);
+
 --
 -- PostgreSQL database dump complete
 --
@@ -1,37 +1,38 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
 
-	"github.com/joho/godotenv"
+	"github.com/Zheng5005/BiteBox/config"
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
 var DB *sql.DB
+
+// SchemaVersion is the schema this code expects. Changes to bitebox.sql bump it and record
+// the new version in schema_migrations, readiness fails while the database is behind.
+// Existing databases get the same changes from upgrade.sql.
+const SchemaVersion = 1
  
-// DBExecutor allows injecting a mock DB or sql.DB
+// DBExecutor allows injecting a mock DB or sql.DB. Code serving a request uses the Context
+// variants, so queries are cancelled with the request and traced under it.
 type DBExecutor interface {
 	Exec(query string, args ...any) (sql.Result, error)
 	Query(query string, args ...any) (*sql.Rows, error)
 	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 }
 
-func InitDB() {
-	errENV := godotenv.Load()
-	if errENV != nil {
-		log.Println("No .env file available")
-	}
-	
+func InitDB(cfg config.Database) {
 	connStr := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		getEnv("DB_HOST", "yourHost"),
-		getEnv("DB_PORT", "yourPort"),
-		getEnv("DB_USER", "yourUser"),
-		getEnv("DB_PASSWORD", "yourPassword"),
-		getEnv("DB_NAME", "bitebox"),
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, cfg.SSLMode,
 	)
 
 	var err error
@@ -47,11 +48,3 @@ func InitDB() {
 
 	log.Println("Database connection established")
 }
-
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
-	}
-	return fallback
-}
-
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/Zheng5005/BiteBox/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracedDB starts a span for each query made with a context, as a child of the request or job
+// that made it. Queries without a context have no parent to hang from and are not traced.
+type TracedDB struct {
+	*sql.DB
+}
+
+func Traced(db *sql.DB) *TracedDB {
+	return &TracedDB{DB: db}
+}
+
+func (t *TracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tracedExec(ctx, t.DB, query, args...)
+}
+
+func (t *TracedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tracedQuery(ctx, t.DB, query, args...)
+}
+
+func (t *TracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tracedQueryRow(ctx, t.DB, query, args...)
+}
+
+// wrapTx traces the queries of transactions started through InTx too
+func (t *TracedDB) wrapTx(tx *sql.Tx) Querier {
+	return tracedTx{tx}
+}
+
+type tracedTx struct {
+	*sql.Tx
+}
+
+func (t tracedTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tracedExec(ctx, t.Tx, query, args...)
+}
+
+func (t tracedTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tracedQuery(ctx, t.Tx, query, args...)
+}
+
+func (t tracedTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tracedQueryRow(ctx, t.Tx, query, args...)
+}
+
+func tracedExec(ctx context.Context, q Querier, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuery(ctx, query)
+	defer span.End()
+
+	result, err := q.ExecContext(ctx, query, args...)
+	tracing.RecordError(span, err)
+	return result, err
+}
+
+func tracedQuery(ctx context.Context, q Querier, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuery(ctx, query)
+	defer span.End()
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	tracing.RecordError(span, err)
+	return rows, err
+}
+
+// tracedQueryRow ends its span once the row is fetched, scan errors such as sql.ErrNoRows
+// are left to the caller
+func tracedQueryRow(ctx context.Context, q Querier, query string, args ...any) *sql.Row {
+	ctx, span := startQuery(ctx, query)
+	defer span.End()
+
+	row := q.QueryRowContext(ctx, query, args...)
+	tracing.RecordError(span, row.Err())
+	return row
+}
+
+func startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, operation(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemNamePostgreSQL, semconv.DBQueryText(strings.TrimSpace(query))),
+	)
+}
+
+// operation names the span after the statement's first word, SELECT, INSERT and so on.
+// Arguments are never part of the name or the recorded query text.
+func operation(query string) string {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "query"
+	}
+	return strings.ToUpper(words[0])
+}
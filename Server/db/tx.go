@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier runs queries. A DBExecutor is one, and so is the transaction InTx hands out,
+// so helpers taking a Querier work either way.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// InTx runs fn in a transaction, committed when fn returns nil and rolled back otherwise.
+// fn's error is returned as is, so callers can still tell their own errors apart.
+func InTx(ctx context.Context, exec DBExecutor, fn func(tx Querier) error) error {
+	tx, err := exec.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var q Querier = tx
+	if wrapper, ok := exec.(interface{ wrapTx(*sql.Tx) Querier }); ok {
+		q = wrapper.wrapTx(tx)
+	}
+
+	if err := fn(q); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,268 @@
+--
+-- Brings a database created from an older bitebox.sql up to schema version 1.
+--
+-- bitebox.sql only runs when the database volume is first created, so an existing volume
+-- keeps its old schema and /readyz reports it. Apply this once, it is safe to run again:
+--
+--   docker compose exec -T db psql -U postgres -d bitebox < Server/db/upgrade.sql
+--
+
+BEGIN;
+
+--
+-- Columns added to existing tables
+--
+
+ALTER TABLE public.comments
+    ADD COLUMN IF NOT EXISTS is_hidden boolean DEFAULT false NOT NULL;
+
+ALTER TABLE public.recipes
+    ADD COLUMN IF NOT EXISTS img_card_url character varying,
+    ADD COLUMN IF NOT EXISTS img_detail_url character varying,
+    ADD COLUMN IF NOT EXISTS steps text,
+    ADD COLUMN IF NOT EXISTS is_active boolean DEFAULT true NOT NULL,
+    ADD COLUMN IF NOT EXISTS created_at timestamp with time zone DEFAULT now() NOT NULL;
+
+ALTER TABLE public.users
+    ALTER COLUMN password TYPE character varying(100),
+    ADD COLUMN IF NOT EXISTS bio character varying(500),
+    ADD COLUMN IF NOT EXISTS role character varying(20) DEFAULT 'user'::character varying NOT NULL,
+    ADD COLUMN IF NOT EXISTS is_suspended boolean DEFAULT false NOT NULL,
+    ADD COLUMN IF NOT EXISTS email_verified boolean DEFAULT false NOT NULL,
+    ADD COLUMN IF NOT EXISTS totp_secret character varying(64),
+    ADD COLUMN IF NOT EXISTS totp_enabled boolean DEFAULT false NOT NULL,
+    ADD COLUMN IF NOT EXISTS totp_last_step bigint,
+    ADD COLUMN IF NOT EXISTS deletion_scheduled_at timestamp with time zone;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_role_check') THEN
+        ALTER TABLE public.users ADD CONSTRAINT users_role_check
+            CHECK (((role)::text = ANY ((ARRAY['user'::character varying, 'moderator'::character varying, 'admin'::character varying])::text[])));
+    END IF;
+END
+$$;
+
+-- Fails while two accounts share an email that differs only in case, merge or rename one first
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON public.users USING btree (lower(email));
+
+
+--
+-- Moderation
+--
+
+CREATE TABLE IF NOT EXISTS public.reports (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    reporter_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    target_type character varying(20) NOT NULL CHECK (target_type IN ('recipe', 'comment')),
+    target_id integer NOT NULL,
+    reason character varying(30) NOT NULL CHECK (reason IN ('spam', 'offensive', 'inappropriate', 'copyright', 'other')),
+    details text,
+    status character varying(20) DEFAULT 'open' NOT NULL CHECK (status IN ('open', 'dismissed', 'actioned')),
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    resolved_at timestamp with time zone,
+    resolved_by integer REFERENCES public.users(id) ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reports_open_unique ON public.reports (reporter_id, target_type, target_id) WHERE status = 'open';
+
+CREATE INDEX IF NOT EXISTS reports_status_idx ON public.reports (status, created_at);
+
+CREATE TABLE IF NOT EXISTS public.moderation_actions (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    moderator_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    report_id integer REFERENCES public.reports(id) ON DELETE SET NULL,
+    action character varying(30) NOT NULL,
+    target_type character varying(20) NOT NULL,
+    target_id integer NOT NULL,
+    target_user_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    note text,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+
+--
+-- Sessions and accounts
+--
+
+CREATE TABLE IF NOT EXISTS public.sessions (
+    id character varying(64) PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    user_agent text,
+    ip character varying(64),
+    device character varying(100),
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    last_seen_at timestamp with time zone DEFAULT now() NOT NULL,
+    revoked_at timestamp with time zone
+);
+
+ALTER TABLE public.sessions
+    ADD COLUMN IF NOT EXISTS user_agent text,
+    ADD COLUMN IF NOT EXISTS ip character varying(64),
+    ADD COLUMN IF NOT EXISTS device character varying(100),
+    ADD COLUMN IF NOT EXISTS last_seen_at timestamp with time zone DEFAULT now() NOT NULL;
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON public.sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    session_id character varying(64) NOT NULL REFERENCES public.sessions(id) ON DELETE CASCADE,
+    token_hash character varying(64) NOT NULL UNIQUE,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.user_tokens (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    purpose character varying(30) NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+    token_hash character varying(64) NOT NULL UNIQUE,
+    expires_at timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.identities (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    provider character varying(50) NOT NULL,
+    subject character varying(255) NOT NULL,
+    email text,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- Google logins were kept on users.google_id before identities, carry them over
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = 'public' AND table_name = 'users' AND column_name = 'google_id') THEN
+        INSERT INTO public.identities (user_id, provider, subject, email)
+        SELECT id, 'google', google_id::text, email FROM public.users WHERE google_id IS NOT NULL
+        ON CONFLICT DO NOTHING;
+
+        ALTER TABLE public.users DROP COLUMN google_id;
+    END IF;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS public.recovery_codes (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    code_hash character varying(64) NOT NULL,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    UNIQUE (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS public.login_failures (
+    key character varying(320) PRIMARY KEY,
+    failures integer DEFAULT 0 NOT NULL,
+    last_failure_at timestamp with time zone NOT NULL,
+    locked_until timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.mfa_challenges (
+    id character varying(64) PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    attempts integer DEFAULT 0 NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.data_exports (
+    id integer GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    status character varying(20) DEFAULT 'pending' NOT NULL CHECK (status IN ('pending', 'processing', 'ready', 'failed', 'expired')),
+    file_path text,
+    error text,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    started_at timestamp with time zone,
+    completed_at timestamp with time zone,
+    expires_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_status_idx ON public.data_exports USING btree (status, created_at);
+
+
+--
+-- Social
+--
+
+CREATE TABLE IF NOT EXISTS public.follows (
+    follower_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    followee_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS follows_followee_idx ON public.follows USING btree (followee_id);
+
+CREATE INDEX IF NOT EXISTS recipes_user_created_idx ON public.recipes USING btree (user_id, created_at DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS public.notifications (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON DELETE CASCADE,
+    type character varying(40) NOT NULL,
+    actor_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    target_type character varying(20) NOT NULL,
+    target_id integer NOT NULL,
+    data jsonb DEFAULT '{}'::jsonb NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    read_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_idx ON public.notifications USING btree (user_id, id DESC);
+
+
+--
+-- Images
+--
+
+CREATE TABLE IF NOT EXISTS public.recipe_images (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    recipe_id integer NOT NULL REFERENCES public.recipes(id) ON DELETE CASCADE,
+    url character varying NOT NULL,
+    card_url character varying NOT NULL,
+    caption character varying(200) DEFAULT ''::character varying NOT NULL,
+    "position" integer DEFAULT 0 NOT NULL,
+    is_cover boolean DEFAULT false NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS recipe_images_recipe_idx ON public.recipe_images USING btree (recipe_id, "position", id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS recipe_images_cover_idx ON public.recipe_images USING btree (recipe_id) WHERE is_cover;
+
+CREATE TABLE IF NOT EXISTS public.assets (
+    id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    url character varying NOT NULL UNIQUE,
+    owner_id integer REFERENCES public.users(id) ON DELETE SET NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_attempt_at timestamp with time zone
+);
+
+-- Databases upgraded before failed deletes were counted
+ALTER TABLE public.assets
+    ADD COLUMN IF NOT EXISTS attempts integer DEFAULT 0 NOT NULL,
+    ADD COLUMN IF NOT EXISTS last_attempt_at timestamp with time zone;
+
+CREATE INDEX IF NOT EXISTS assets_created_idx ON public.assets USING btree (created_at);
+
+
+--
+-- Version
+--
+
+CREATE TABLE IF NOT EXISTS public.schema_migrations (
+    version integer PRIMARY KEY,
+    applied_at timestamp with time zone DEFAULT now() NOT NULL
+);
+
+-- Keep in step with db.SchemaVersion
+INSERT INTO public.schema_migrations (version) VALUES (1) ON CONFLICT (version) DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,68 @@
+// Package events is an in-process dispatcher that lets handlers announce what happened
+// without knowing who reacts to it (notifications, realtime updates, ...).
+package events
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Zheng5005/BiteBox/logging"
+)
+
+// Event types published by the handlers
+const (
+	CommentCreated   = "comment.created"
+	RecipeRated      = "recipe.rated"
+	UserFollowed     = "user.followed"
+	ContentModerated = "content.moderated"
+	// NotificationCreated carries the recipient in Data["user_id"] and the notification in Data["id"]
+	NotificationCreated = "notification.created"
+)
+
+type Event struct {
+	Type string
+	// ActorID is the user who caused the event, empty when it was the system or a moderator
+	ActorID    string
+	TargetType string
+	TargetID   string
+	// Data holds details specific to the event type
+	Data map[string]string
+}
+
+type Handler func(ctx context.Context, e Event) error
+
+type Dispatcher struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: map[string][]Handler{}}
+}
+
+func (d *Dispatcher) Subscribe(eventType string, handler Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[eventType] = append(d.handlers[eventType], handler)
+}
+
+// Publish runs the subscribers in order. Their errors are logged, never returned:
+// the request that published the event already succeeded. Subscribers get the request's
+// context without its cancellation, so they keep its trace and logger but still run when the
+// client hangs up. A nil dispatcher does nothing.
+func (d *Dispatcher) Publish(ctx context.Context, e Event) {
+	if d == nil {
+		return
+	}
+
+	d.mu.RLock()
+	handlers := d.handlers[e.Type]
+	d.mu.RUnlock()
+
+	ctx = context.WithoutCancel(ctx)
+	for _, handler := range handlers {
+		if err := handler(ctx, e); err != nil {
+			logging.FromContext(ctx).Error("Event handler failed", "event", e.Type, "err", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDispatcher_PublishesToSubscribers(t *testing.T) {
+	d := NewDispatcher()
+
+	var got []string
+	d.Subscribe(UserFollowed, func(ctx context.Context, e Event) error {
+		got = append(got, "first:"+e.TargetID)
+		return errors.New("ignored")
+	})
+	d.Subscribe(UserFollowed, func(ctx context.Context, e Event) error {
+		got = append(got, "second:"+e.TargetID)
+		return nil
+	})
+	d.Subscribe(CommentCreated, func(ctx context.Context, e Event) error {
+		t.Errorf("Unexpected call for %s", e.Type)
+		return nil
+	})
+
+	d.Publish(context.Background(), Event{Type: UserFollowed, ActorID: "5", TargetType: "user", TargetID: "7"})
+
+	if len(got) != 2 || got[0] != "first:7" || got[1] != "second:7" {
+		t.Errorf("Expected both subscribers in order, got %v", got)
+	}
+}
+
+func TestDispatcher_NilIsNoop(t *testing.T) {
+	var d *Dispatcher
+	d.Publish(context.Background(), Event{Type: CommentCreated})
+}
+
+func TestDispatcher_PassesContextWithoutCancel(t *testing.T) {
+	type key struct{}
+	d := NewDispatcher()
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "request"))
+	cancel()
+
+	d.Subscribe(CommentCreated, func(ctx context.Context, e Event) error {
+		if ctx.Value(key{}) != "request" {
+			t.Errorf("Expected the publisher's context values")
+		}
+		if ctx.Err() != nil {
+			t.Errorf("Expected a context that outlives the request, got %v", ctx.Err())
+		}
+		return nil
+	})
+
+	d.Publish(ctx, Event{Type: CommentCreated})
+}
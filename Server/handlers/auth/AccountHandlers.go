@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purposes of the single-use tokens sent by email
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+)
+
+const (
+	PasswordResetTTL     = time.Hour
+	EmailVerificationTTL = 24 * time.Hour
+)
+
+func (h *AuthHandler) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Email == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// The answer is the same whether or not the email exists, so it can't be used to find accounts.
+	// The token and mail are handled after responding, or the SMTP round trip would time it apart.
+	var userID string
+	err := h.DB.QueryRowContext(r.Context(), "SELECT id FROM users WHERE lower(email) = lower($1)", input.Email).Scan(&userID)
+	if err == nil {
+		h.inBackground(r.Context(), func(ctx context.Context) {
+			token, err := h.createUserToken(ctx, userID, PurposePasswordReset, PasswordResetTTL)
+			if err != nil {
+				return
+			}
+			body := fmt.Sprintf(
+				"Someone asked to reset your BiteBox password.\n\nUse this link within the next hour to choose a new one:\n%s/reset-password?token=%s\n\nIf it wasn't you, you can ignore this email.",
+				h.AppURL, token,
+			)
+			if err := h.Mailer.Send(input.Email, "Reset your BiteBox password", body); err != nil {
+				logging.FromContext(ctx).Error("Mail error", "err", err)
+			}
+		})
+	} else if err != sql.ErrNoRows {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the email is registered, a reset link was sent"))
+}
+
+func (h *AuthHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Token == "" || input.Password == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.consumeUserToken(r.Context(), input.Token, PurposePasswordReset)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error resetting password", http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), "UPDATE users SET password = $1 WHERE id = $2", hashedPassword, userID); err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error resetting password", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password shouldn't stay logged in
+	if _, err := h.DB.ExecContext(r.Context(), "UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Password updated"))
+}
+
+func (h *AuthHandler) RequestVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var email string
+	var verified bool
+	err = h.DB.QueryRowContext(r.Context(), "SELECT email, email_verified FROM users WHERE id = $1", userID).Scan(&email, &verified)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error loading user", http.StatusInternalServerError)
+		return
+	}
+
+	if verified {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+
+	if err := h.sendVerificationEmail(r.Context(), userID, email); err != nil {
+		http.Error(w, "Error sending verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Verification email sent"))
+}
+
+func (h *AuthHandler) ConfirmVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.consumeUserToken(r.Context(), input.Token, PurposeEmailVerification)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error verifying email", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), "UPDATE users SET email_verified = true WHERE id = $1", userID); err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error verifying email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Email verified"))
+}
+
+func (h *AuthHandler) sendVerificationEmail(ctx context.Context, userID, email string) error {
+	token, err := h.createUserToken(ctx, userID, PurposeEmailVerification, EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Welcome to BiteBox!\n\nConfirm your email address with this link:\n%s/verify-email?token=%s",
+		h.AppURL, token,
+	)
+	if err := h.Mailer.Send(email, "Confirm your BiteBox email", body); err != nil {
+		logging.FromContext(ctx).Error("Mail error", "err", err)
+		return err
+	}
+	return nil
+}
+
+// createUserToken invalidates the user's pending tokens for the purpose and stores a new one
+func (h *AuthHandler) createUserToken(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	token, err := utils.NewRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = h.DB.ExecContext(ctx,
+		"UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		userID, purpose,
+	)
+	if err != nil {
+		logging.FromContext(ctx).Error("DB error", "err", err)
+		return "", err
+	}
+
+	_, err = h.DB.ExecContext(ctx,
+		"INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		userID, purpose, utils.HashToken(token), time.Now().Add(ttl),
+	)
+	if err != nil {
+		logging.FromContext(ctx).Error("DB error", "err", err)
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeUserToken marks a valid token as used and returns its user, sql.ErrNoRows if there is none
+func (h *AuthHandler) consumeUserToken(ctx context.Context, token, purpose string) (string, error) {
+	var userID string
+	err := h.DB.QueryRowContext(ctx, `
+		UPDATE user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`, utils.HashToken(token), purpose).Scan(&userID)
+	return userID, err
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestForgotPassword_SendsResetLink(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	var outbox bytes.Buffer
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(&outbox))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE lower(email) = lower($1)")).
+		WithArgs("jd@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW()")).
+		WithArgs("1", PurposePasswordReset).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)")).
+		WithArgs("1", PurposePasswordReset, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/password/forgot", strings.NewReader(`{"email": "jd@gmail.com"}`))
+	rr := httptest.NewRecorder()
+	handler.ForgotPasswordHandler(rr, req)
+	handler.Wait()
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202 Accepted, got %d", rr.Code)
+	}
+
+	if !strings.Contains(outbox.String(), "To: jd@gmail.com") || !strings.Contains(outbox.String(), "/reset-password?token=") {
+		t.Errorf("Expected a reset email, got: %s", outbox.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestForgotPassword_UnknownEmail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	var outbox bytes.Buffer
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(&outbox))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE lower(email) = lower($1)")).
+		WithArgs("nobody@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/password/forgot", strings.NewReader(`{"email": "nobody@gmail.com"}`))
+	rr := httptest.NewRecorder()
+	handler.ForgotPasswordHandler(rr, req)
+	handler.Wait()
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202 Accepted, got %d", rr.Code)
+	}
+
+	if outbox.Len() != 0 {
+		t.Errorf("Expected no email, got: %s", outbox.String())
+	}
+}
+
+// slowMailer stands in for an SMTP server that takes a while to answer
+type slowMailer struct {
+	release chan struct{}
+}
+
+func (m slowMailer) Send(to, subject, body string) error {
+	<-m.release
+	return nil
+}
+
+func TestForgotPassword_AnswersBeforeMailIsSent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	mailer := slowMailer{release: make(chan struct{})}
+	handler := NewAuthHandler(db, "other_key", mailer)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE lower(email) = lower($1)")).
+		WithArgs("jd@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW()")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_tokens")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/password/forgot", strings.NewReader(`{"email": "jd@gmail.com"}`))
+	rr := httptest.NewRecorder()
+	handler.ForgotPasswordHandler(rr, req)
+
+	// The mailer is still blocked, the answer went out regardless
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202 Accepted, got %d", rr.Code)
+	}
+
+	close(mailer.release)
+	handler.Wait()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestResetPassword_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(&bytes.Buffer{}))
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW()")).
+		WithArgs(utils.HashToken("reset-token"), PurposePasswordReset).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("1"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET password = $1 WHERE id = $2")).
+		WithArgs(sqlmock.AnyArg(), "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1")).
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	body := `{"token": "reset-token", "password": "new-password"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/password/reset", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ResetPasswordHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestConfirmVerification_ExpiredToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(&bytes.Buffer{}))
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW()")).
+		WithArgs(utils.HashToken("old-token"), PurposeEmailVerification).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/verify/confirm", strings.NewReader(`{"token": "old-token"}`))
+	rr := httptest.NewRecorder()
+	handler.ConfirmVerificationHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 Bad Request, got %d", rr.Code)
+	}
+}
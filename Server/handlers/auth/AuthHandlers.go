@@ -1,16 +1,23 @@
 package auth
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
-	"time"
 
-	"github.com/Zheng5005/BiteBox/lib"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/Zheng5005/BiteBox/imaging"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/metrics"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// uniqueViolation is PostgreSQL's error code for a duplicate key, users_email_lower_idx
+// makes it the answer to an email that is already registered, whatever its case
+const uniqueViolation = "23505"
+
 func (h *AuthHandler) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
@@ -32,19 +39,23 @@ func (h *AuthHandler) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Upload image to Cloudinary
-	file, fileHeader, err := r.FormFile("image")
+	//Process and store the uploaded image
+	file, _, err := r.FormFile("image")
 	var imageURL string
 
 	if err == nil {
 		defer file.Close()
 
-		// Assume you have a cloudinary uploader function
-		imageURL, err = lib.UploadToCloudinary(file, fileHeader.Filename)
-		if err != nil {
+		urls, err := imaging.Save(r.Context(), h.Images, file, imaging.Avatar)
+		if imaging.Rejected(err) {
+			http.Error(w, "Invalid image: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logging.FromContext(r.Context()).Error("Image upload error", "err", err)
 			http.Error(w, "Error uploading image", http.StatusInternalServerError)
 			return
 		}
+		imageURL = urls[imaging.Avatar.Name]
 	} else if err != http.ErrMissingFile {
 		http.Error(w, "Error reading file", http.StatusBadRequest)
 		return
@@ -58,17 +69,28 @@ func (h *AuthHandler) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//Save user to DB
-	_, err = h.DB.Exec(
-		"INSERT INTO users (name, email, password, url_photo) VALUES ($1, $2, $3, $4)",
+	var userID string
+	err = h.DB.QueryRowContext(r.Context(),
+		"INSERT INTO users (name, email, password, url_photo) VALUES ($1, $2, $3, $4) RETURNING id",
 		name, email, hashedPassword, imageURL,
-	)
+	).Scan(&userID)
 
-	if err != nil {
-		log.Panicln(err)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		http.Error(w, "Email already registered", http.StatusConflict)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("Error creating user", "err", err)
 		http.Error(w, "Error creating user", http.StatusInternalServerError)
 		return
 	}
 
+	// The account works without it, the user can ask for a new link later. Sent after
+	// responding so a slow SMTP server doesn't hold up the signup.
+	h.inBackground(r.Context(), func(ctx context.Context) {
+		h.sendVerificationEmail(ctx, userID, email)
+	})
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("User created"))
 }
@@ -89,31 +111,53 @@ func (h *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request)  {
 		return
 	}
 
-	var userID, hashedPassword, name, url_photo string
-	err := h.DB.QueryRow("SELECT id, password, name, COALESCE(url_photo, '') FROM users WHERE email = $1", input.Email).Scan(&userID, &hashedPassword, &name, &url_photo)
+	wait, err := h.loginLockedFor(r, emailKey(input.Email))
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(input.Password)); err != nil {
+	if wait > 0 {
+		metrics.Logins.WithLabelValues(metrics.LoginLockedOut).Inc()
+		writeLockedOut(w, wait)
+		return
+	}
+
+	var userID, hashedPassword, name, url_photo, role string
+	var suspended, totpEnabled bool
+	err = h.DB.QueryRowContext(r.Context(), "SELECT id, COALESCE(password, ''), name, COALESCE(url_photo, ''), role, is_suspended, totp_enabled FROM users WHERE lower(email) = lower($1)", input.Email).Scan(&userID, &hashedPassword, &name, &url_photo, &role, &suspended, &totpEnabled)
+	if err != nil && err != sql.ErrNoRows {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		return
+	}
+
+	// Unknown emails and accounts without a password still pay for a bcrypt compare,
+	// so response times don't tell which emails are registered
+	hash := []byte(hashedPassword)
+	if err == sql.ErrNoRows || hashedPassword == "" {
+		hash = dummyHash()
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(input.Password)); err != nil || hashedPassword == "" {
+		h.recordLoginFailure(r, emailKey(input.Email))
+		metrics.Logins.WithLabelValues(metrics.LoginFailed).Inc()
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
-	 token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"name": name,
-		"url_photo": url_photo,
-		"exp": time.Now().Add(24 * time.Hour).Unix(),
-	})
 
-	secret := []byte(h.SecretKey)
-	tokenString, err := token.SignedString(secret)
-	if err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
+	h.clearLoginFailures(r.Context(), emailKey(input.Email))
+
+	if suspended {
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	if totpEnabled {
+		h.writeChallenge(w, r, userID)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+	metrics.Logins.WithLabelValues(metrics.LoginSucceeded).Inc()
+	h.startSession(w, r, sessionUser{ID: userID, Name: name, URLPhoto: url_photo, Role: role})
 }
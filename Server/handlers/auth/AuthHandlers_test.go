@@ -3,6 +3,7 @@ package auth
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +12,9 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/utils"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -21,7 +25,7 @@ func TestSignIn_Success(t *testing.T)  {
 	}
 	defer db.Close()
 
-	handler := NewAuthHandler(db, "other_key")
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
 
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
@@ -33,12 +37,19 @@ func TestSignIn_Success(t *testing.T)  {
 	req := httptest.NewRequest(http.MethodPost, "/api/auth/signin", &body)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users (name, email, password, url_photo)")).
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (name, email, password, url_photo)")).
 		WithArgs("Jane", "jd@gmail.com", sqlmock.AnyArg(), "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_tokens SET used_at = NOW()")).
+		WithArgs("1", "email_verification").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)")).
+		WithArgs("1", "email_verification", sqlmock.AnyArg(), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	rr := httptest.NewRecorder()
 	handler.SignUpHandler(rr, req)
+	handler.Wait()
 
 	if rr.Code != http.StatusCreated {
 		t.Errorf("Expected status 201 Created, got %d", rr.Code)
@@ -47,6 +58,46 @@ func TestSignIn_Success(t *testing.T)  {
 	if strings.TrimSpace(rr.Body.String()) != "User created" {
 		t.Errorf("Expected body 'User created', got '%s'", rr.Body.String())
 	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestSignIn_EmailTaken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("name", "Jane")
+	_ = writer.WriteField("email", "JD@gmail.com")
+	_ = writer.WriteField("password", "123")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/signin", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (name, email, password, url_photo)")).
+		WithArgs("Jane", "JD@gmail.com", sqlmock.AnyArg(), "").
+		WillReturnError(&pq.Error{Code: uniqueViolation, Constraint: "users_email_lower_idx"})
+
+	rr := httptest.NewRecorder()
+	handler.SignUpHandler(rr, req)
+	handler.Wait()
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 Conflict, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
 }
 
 func TestLogin_Success(t *testing.T) {
@@ -56,20 +107,29 @@ func TestLogin_Success(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := NewAuthHandler(db, "other_key")
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
 	
 	email := "jd@gmail.com"
 	password := "123"
 	hashed, _ := bcrypt.GenerateFromPassword([]byte(password),bcrypt.DefaultCost)
 
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, password, name, COALESCE(url_photo, '') FROM users WHERE email = $1")).
+	expectNotLockedOut(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, COALESCE(password, ''), name, COALESCE(url_photo, ''), role, is_suspended, totp_enabled FROM users WHERE lower(email) = lower($1)")).
 		WithArgs(email).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "name", "url_photo"}).
-			AddRow("1", string(hashed), "Test User", "photo.jpg"))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "name", "url_photo", "role", "is_suspended", "totp_enabled"}).
+			AddRow("1", string(hashed), "Test User", "photo.jpg", "moderator", false, false))
+	expectFailuresCleared(mock)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO sessions (id, user_id, user_agent, ip, device)")).
+		WithArgs(sqlmock.AnyArg(), "1", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0", "192.0.2.1", "Firefox on Linux").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens (session_id, token_hash, expires_at)")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	body := `{"email": "jd@gmail.com", "password": "123"}`
 	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0")
 
 	rr := httptest.NewRecorder()
 	handler.LoginHandler(rr, req)
@@ -84,6 +144,55 @@ func TestLogin_Success(t *testing.T) {
 	}
 
 	if _, ok := resp["token"]; !ok {
-		t.Errorf("Expected JWT token in response, got: %v", resp)
+		t.Fatalf("Expected JWT token in response, got: %v", resp)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+resp["token"])
+	claims, err := utils.ParseClaims(r, "other_key")
+	if err != nil {
+		t.Fatalf("Failed to parse issued token: %v", err)
+	}
+
+	if role := utils.ClaimsRole(claims); role != "moderator" {
+		t.Errorf("Expected role claim 'moderator', got %q", role)
+	}
+
+	if sid, _ := claims["sid"].(string); sid == "" {
+		t.Errorf("Expected session ID claim in token")
+	}
+
+	if resp["refresh_token"] == "" {
+		t.Errorf("Expected refresh token in response, got: %v", resp)
+	}
+}
+
+func TestLogin_Suspended(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.DefaultCost)
+
+	expectNotLockedOut(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE lower(email) = lower($1)")).
+		WithArgs("jd@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "name", "url_photo", "role", "is_suspended", "totp_enabled"}).
+			AddRow("1", string(hashed), "Test User", "", "user", true, false))
+	expectFailuresCleared(mock)
+
+	body := `{"email": "jd@gmail.com", "password": "123"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler.LoginHandler(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 Forbidden, got %d", rr.Code)
 	}
 }
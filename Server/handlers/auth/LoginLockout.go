@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Failed logins are counted per account and per IP, a wrong password against the email and a
+// wrong second factor against the user ID. Past the free attempts every new failure
+// doubles the lockout, starting at LockoutBase and capped at LockoutMax.
+const (
+	EmailFreeAttempts = 5
+	// IPs get more room since many users can share one behind a NAT
+	IPFreeAttempts = 20
+	LockoutBase    = 30 * time.Second
+	LockoutMax     = 15 * time.Minute
+	// FailureWindow is how long without failures before the count starts over
+	FailureWindow = time.Hour
+)
+
+// LockoutDuration is how long a key stays locked after its nth failure
+func LockoutDuration(failures, freeAttempts int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+
+	exp := failures - freeAttempts
+	if exp > 16 {
+		return LockoutMax
+	}
+
+	d := LockoutBase * time.Duration(math.Pow(2, float64(exp)))
+	if d > LockoutMax {
+		return LockoutMax
+	}
+	return d
+}
+
+// dummyHash is compared against when the email is unknown, so those answers take as long as a wrong password
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("bitebox-dummy-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func userKey(userID string) string {
+	return "user:" + userID
+}
+
+func ipKey(r *http.Request) string {
+	return "ip:" + utils.ClientIP(r)
+}
+
+// loginLockedFor returns how long until the account or the IP of the request can try again
+func (h *AuthHandler) loginLockedFor(r *http.Request, account string) (time.Duration, error) {
+	now := time.Now()
+	rows, err := h.DB.QueryContext(r.Context(),
+		"SELECT locked_until FROM login_failures WHERE key IN ($1, $2) AND locked_until > $3",
+		account, ipKey(r), now,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		return 0, err
+	}
+	defer rows.Close()
+
+	var wait time.Duration
+	for rows.Next() {
+		var lockedUntil time.Time
+		if err := rows.Scan(&lockedUntil); err != nil {
+			logging.FromContext(r.Context()).Error("DB error", "err", err)
+			return 0, err
+		}
+		if d := lockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait, rows.Err()
+}
+
+// recordLoginFailure counts the failure for the account and the IP and locks whichever went past its free attempts
+func (h *AuthHandler) recordLoginFailure(r *http.Request, account string) {
+	now := time.Now()
+	keys := []struct {
+		key  string
+		free int
+	}{
+		{account, EmailFreeAttempts},
+		{ipKey(r), IPFreeAttempts},
+	}
+
+	for _, k := range keys {
+		var failures int
+		err := h.DB.QueryRowContext(r.Context(), `
+			INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, $2)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_failures.last_failure_at < $3 THEN 1 ELSE login_failures.failures + 1 END,
+				last_failure_at = $2
+			RETURNING failures`,
+			k.key, now, now.Add(-FailureWindow),
+		).Scan(&failures)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("DB error", "err", err)
+			continue
+		}
+
+		if d := LockoutDuration(failures, k.free); d > 0 {
+			if _, err := h.DB.ExecContext(r.Context(), "UPDATE login_failures SET locked_until = $2 WHERE key = $1", k.key, now.Add(d)); err != nil {
+				logging.FromContext(r.Context()).Error("DB error", "err", err)
+			}
+		}
+	}
+}
+
+// clearLoginFailures resets the account counter, the IP one is left alone so one
+// account the attacker owns can't be used to reset it
+func (h *AuthHandler) clearLoginFailures(ctx context.Context, account string) {
+	if _, err := h.DB.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", account); err != nil {
+		logging.FromContext(ctx).Error("DB error", "err", err)
+	}
+}
+
+// PurgeLoginFailures deletes the counters that would start over at the next failure anyway
+// and are no longer locked, and the expired 2FA challenges. Runs as a background job.
+func (h *AuthHandler) PurgeLoginFailures(ctx context.Context) error {
+	now := time.Now()
+	_, err := h.DB.ExecContext(ctx,
+		"DELETE FROM login_failures WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $2)",
+		now.Add(-FailureWindow), now,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.DB.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE expires_at < $1", now)
+	return err
+}
+
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+}
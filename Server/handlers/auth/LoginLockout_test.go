@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
+)
+
+func expectNotLockedOut(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT locked_until FROM login_failures")).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}))
+}
+
+func expectFailuresCleared(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM login_failures WHERE key = $1")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestLockoutDuration(t *testing.T) {
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{EmailFreeAttempts - 1, 0},
+		{EmailFreeAttempts, LockoutBase},
+		{EmailFreeAttempts + 1, 2 * LockoutBase},
+		{EmailFreeAttempts + 3, 8 * LockoutBase},
+		{EmailFreeAttempts + 100, LockoutMax},
+	}
+
+	for _, c := range cases {
+		if got := LockoutDuration(c.failures, EmailFreeAttempts); got != c.want {
+			t.Errorf("after %d failures expected %s, got %s", c.failures, c.want, got)
+		}
+	}
+}
+
+func TestLogin_UnknownEmailCountsAsFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	expectNotLockedOut(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE lower(email) = lower($1)")).
+		WithArgs("Nobody@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "name", "url_photo", "role", "is_suspended", "totp_enabled"}))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO login_failures")).
+		WithArgs("email:nobody@gmail.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(EmailFreeAttempts))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE login_failures SET locked_until = $2")).
+		WithArgs("email:nobody@gmail.com", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO login_failures")).
+		WithArgs("ip:192.0.2.1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+
+	body := `{"email": "Nobody@gmail.com", "password": "123"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.LoginHandler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 Unauthorized, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestLogin_LockedOut(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT locked_until FROM login_failures")).
+		WithArgs("email:jd@gmail.com", "ip:192.0.2.1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(time.Now().Add(90 * time.Second)))
+
+	body := `{"email": "jd@gmail.com", "password": "123"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.LoginHandler(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 Too Many Requests, got %d", rr.Code)
+	}
+
+	retry, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || retry < 89 || retry > 90 {
+		t.Errorf("Expected Retry-After around 90 seconds, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPurgeLoginFailures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM login_failures WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $2)")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mfa_challenges WHERE expires_at < $1")).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := handler.PurgeLoginFailures(context.Background()); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
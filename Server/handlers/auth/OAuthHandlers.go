@@ -0,0 +1,426 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oauthStateCookie = "oauth_state"
+	oauthStateTTL    = 10 * time.Minute
+	linkTokenTTL     = 5 * time.Minute
+)
+
+func (h *AuthHandler) OAuthStartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("provider")
+	provider, ok := h.Providers[name]
+	if !ok {
+		http.Error(w, "Login provider is not configured", http.StatusNotFound)
+		return
+	}
+
+	state, err1 := utils.NewRandomToken(24)
+	nonce, err2 := utils.NewRandomToken(24)
+	verifier, err3 := utils.NewRandomToken(32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return
+	}
+
+	flow := jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oauthStateTTL).Unix(),
+	}
+
+	// Linking goes through the same flow, started with a link token from LinkIdentityHandler
+	if link := r.URL.Query().Get("link"); link != "" {
+		userID, err := h.parseLinkToken(link, name)
+		if err != nil {
+			http.Error(w, "Invalid link token", http.StatusUnauthorized)
+			return
+		}
+		flow["link_user_id"] = userID
+	}
+
+	// The flow state lives in a short lived signed cookie, so nothing is stored until the user comes back
+	cookieValue, err := jwt.NewWithClaims(jwt.SigningMethodHS256, flow).SignedString([]byte(h.SecretKey))
+	if err != nil {
+		http.Error(w, "Error starting login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    cookieValue,
+		Path:     "/api/auth/" + name,
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+func (h *AuthHandler) OAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("provider")
+	provider, ok := h.Providers[name]
+	if !ok {
+		http.Error(w, "Login provider is not configured", http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("error") != "" {
+		http.Error(w, "Login was not completed", http.StatusUnauthorized)
+		return
+	}
+
+	flow, err := h.readOAuthState(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/api/auth/" + name, MaxAge: -1})
+
+	identity, err := provider.Exchange(r.Context(), r.URL.Query().Get("code"), flow["verifier"], flow["nonce"])
+	if err != nil {
+		logging.FromContext(r.Context()).Error("OIDC error", "err", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	if userID := flow["link_user_id"]; userID != "" {
+		h.pendingLink(w, r, userID, name, identity)
+		return
+	}
+
+	user, suspended, totpEnabled, err := h.userForIdentity(r.Context(), name, identity)
+	if errors.Is(err, errAccountExists) {
+		http.Redirect(w, r, h.AppURL+"/login?oauth_error=account_exists", http.StatusFound)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error signing in", http.StatusInternalServerError)
+		return
+	}
+
+	if suspended {
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	// The provider only replaces the password, accounts with 2FA still finish through LoginTwoFactorHandler
+	if totpEnabled {
+		challenge, err := h.challengeToken(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, "Error generating token", http.StatusInternalServerError)
+			return
+		}
+		fragment := url.Values{"mfa_required": {"true"}, "challenge_token": {challenge}}
+		http.Redirect(w, r, h.AppURL+"/oauth/callback#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	sessionID, err := h.createSession(r, user.ID)
+	if err != nil {
+		http.Error(w, "Error creating session", http.StatusInternalServerError)
+		return
+	}
+
+	token, refreshToken, err := h.issueTokens(r.Context(), h.DB, user, sessionID)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	// Tokens go in the fragment, which browsers never send to a server
+	fragment := url.Values{"token": {token}, "refresh_token": {refreshToken}}
+	http.Redirect(w, r, h.AppURL+"/oauth/callback#"+fragment.Encode(), http.StatusFound)
+}
+
+// LinkIdentityHandler hands a logged in user the URL that starts attaching an external identity
+// to their account. The client finishes with ConfirmLinkHandler once the provider sends it back.
+func (h *AuthHandler) LinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("provider")
+	if _, ok := h.Providers[name]; !ok {
+		http.Error(w, "Login provider is not configured", http.StatusNotFound)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Not a user_id claim, so this token can't pass for an access token
+	link, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"link_user_id": userID,
+		"provider":     name,
+		"exp":          time.Now().Add(linkTokenTTL).Unix(),
+	}).SignedString([]byte(h.SecretKey))
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": "/api/auth/" + name + "/start?link=" + url.QueryEscape(link),
+	})
+}
+
+// pendingLink sends the identity back to the client as a signed code instead of linking it here.
+// Anyone can get a start URL for their own account and have someone else open it, so the link
+// is only made by ConfirmLinkHandler, for the logged in user who started it.
+func (h *AuthHandler) pendingLink(w http.ResponseWriter, r *http.Request, userID, provider string, identity *lib.OIDCIdentity) {
+	code, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"pending_link_user_id": userID,
+		"provider":             provider,
+		"subject":              identity.Subject,
+		"email":                identity.Email,
+		"exp":                  time.Now().Add(linkTokenTTL).Unix(),
+	}).SignedString([]byte(h.SecretKey))
+	if err != nil {
+		http.Error(w, "Error linking account", http.StatusInternalServerError)
+		return
+	}
+
+	fragment := url.Values{"link_provider": {provider}, "link_code": {code}}
+	http.Redirect(w, r, h.AppURL+"/profile#"+fragment.Encode(), http.StatusFound)
+}
+
+// ConfirmLinkHandler attaches the identity from a pending link code to the logged in user,
+// provided they are the one who started linking
+func (h *AuthHandler) ConfirmLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider := r.PathValue("provider")
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := h.parseSigned(input.Code)
+	if err != nil || claims["provider"] != provider {
+		http.Error(w, "Invalid link code", http.StatusBadRequest)
+		return
+	}
+	subject, _ := claims["subject"].(string)
+	email, _ := claims["email"].(string)
+	if subject == "" {
+		http.Error(w, "Invalid link code", http.StatusBadRequest)
+		return
+	}
+	if claims["pending_link_user_id"] != userID {
+		http.Error(w, "Linking was started by another account", http.StatusForbidden)
+		return
+	}
+
+	var ownerID string
+	err = h.DB.QueryRowContext(r.Context(),
+		"SELECT user_id FROM identities WHERE provider = $1 AND subject = $2",
+		provider, subject,
+	).Scan(&ownerID)
+
+	switch {
+	case err == nil && ownerID != userID:
+		http.Error(w, "This login is linked to another account", http.StatusConflict)
+		return
+	case err == nil:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"linked": provider})
+		return
+	case err != sql.ErrNoRows:
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error linking account", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(), `
+		INSERT INTO identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, provider) DO NOTHING`,
+		userID, provider, subject, email,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error linking account", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "Another login from this provider is already linked", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"linked": provider})
+}
+
+// parseSigned checks a token this server signed and returns its claims
+func (h *AuthHandler) parseSigned(value string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(value, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Invalid method")
+		}
+		return []byte(h.SecretKey), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return claims, nil
+}
+
+func (h *AuthHandler) parseLinkToken(link, provider string) (string, error) {
+	claims, err := h.parseSigned(link)
+	if err != nil {
+		return "", err
+	}
+
+	userID, _ := claims["link_user_id"].(string)
+	if userID == "" || claims["provider"] != provider {
+		return "", fmt.Errorf("Invalid token")
+	}
+
+	return userID, nil
+}
+
+// readOAuthState checks the state cookie against the state the provider sent back
+func (h *AuthHandler) readOAuthState(r *http.Request) (map[string]string, error) {
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		return nil, fmt.Errorf("Missing login state")
+	}
+
+	claims, err := h.parseSigned(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid login state")
+	}
+
+	flow := map[string]string{}
+	for key, value := range claims {
+		if s, ok := value.(string); ok {
+			flow[key] = s
+		}
+	}
+
+	state := r.URL.Query().Get("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(flow["state"])) != 1 {
+		return nil, fmt.Errorf("Invalid login state")
+	}
+
+	return flow, nil
+}
+
+// errAccountExists is returned by userForIdentity for an email that belongs to an account it
+// won't link on its own
+var errAccountExists = errors.New("an account with this email already exists")
+
+// userForIdentity finds the account linked to the provider subject, links one by verified email,
+// or creates a new password-less account
+func (h *AuthHandler) userForIdentity(ctx context.Context, provider string, identity *lib.OIDCIdentity) (sessionUser, bool, bool, error) {
+	user := sessionUser{}
+	var suspended, totpEnabled bool
+
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT u.id, u.name, COALESCE(u.url_photo, ''), u.role, u.is_suspended, u.totp_enabled
+		FROM identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2`,
+		provider, identity.Subject,
+	).Scan(&user.ID, &user.Name, &user.URLPhoto, &user.Role, &suspended, &totpEnabled)
+	if err != sql.ErrNoRows {
+		return user, suspended, totpEnabled, err
+	}
+
+	// An account with the same email is only taken over when both sides verified the address.
+	// Otherwise whoever signed up with it may not own it, and could keep a password into
+	// the account; its owner logs in and links the provider from their profile instead.
+	if identity.Email != "" {
+		var linkable bool
+		err = h.DB.QueryRowContext(ctx, `
+			SELECT u.id, u.name, COALESCE(u.url_photo, ''), u.role, u.is_suspended, u.totp_enabled,
+				u.email_verified AND NOT EXISTS (SELECT 1 FROM identities i WHERE i.user_id = u.id AND i.provider = $2)
+			FROM users u
+			WHERE lower(u.email) = lower($1)`,
+			identity.Email, provider,
+		).Scan(&user.ID, &user.Name, &user.URLPhoto, &user.Role, &suspended, &totpEnabled, &linkable)
+		if err == nil {
+			if !linkable || !identity.EmailVerified {
+				return user, suspended, totpEnabled, errAccountExists
+			}
+			return user, suspended, totpEnabled, h.insertIdentity(ctx, user.ID, provider, identity)
+		} else if err != sql.ErrNoRows {
+			return user, suspended, totpEnabled, err
+		}
+	}
+
+	user.Name = identity.Name
+	if user.Name == "" {
+		user.Name, _, _ = strings.Cut(identity.Email, "@")
+	}
+	user.URLPhoto = identity.Picture
+
+	err = h.DB.QueryRowContext(ctx,
+		"INSERT INTO users (name, email, url_photo, email_verified) VALUES ($1, $2, $3, $4) RETURNING id, role",
+		user.Name, identity.Email, user.URLPhoto, identity.EmailVerified,
+	).Scan(&user.ID, &user.Role)
+	if err != nil {
+		return user, false, false, err
+	}
+
+	return user, false, false, h.insertIdentity(ctx, user.ID, provider, identity)
+}
+
+func (h *AuthHandler) insertIdentity(ctx context.Context, userID, provider string, identity *lib.OIDCIdentity) error {
+	_, err := h.DB.ExecContext(ctx,
+		"INSERT INTO identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)",
+		userID, provider, identity.Subject, identity.Email,
+	)
+	return err
+}
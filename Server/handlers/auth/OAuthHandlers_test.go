@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/lib/oidctest"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+// completeGoogleLogin runs start and the provider round trip, and returns the callback request
+func completeGoogleLogin(t *testing.T, handler *AuthHandler, startURL string) *http.Request {
+	t.Helper()
+
+	startReq := httptest.NewRequest(http.MethodGet, startURL, nil)
+	startReq.SetPathValue("provider", "google")
+	startRR := httptest.NewRecorder()
+	handler.OAuthStartHandler(startRR, startReq)
+	if startRR.Code != http.StatusFound {
+		t.Fatalf("Expected start to redirect, got %d", startRR.Code)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(startRR.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to reach mock provider: %v", err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || callback.Query().Get("code") == "" {
+		t.Fatalf("Expected provider to redirect back with a code, got %q", resp.Header.Get("Location"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.SetPathValue("provider", "google")
+	for _, c := range startRR.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func newGoogleHandler(t *testing.T) (*AuthHandler, sqlmock.Sqlmock, *oidctest.Server) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider := oidctest.NewServer("bitebox")
+	t.Cleanup(provider.Close)
+
+	google, err := lib.NewOIDCProvider(context.Background(), "google", provider.URL, "bitebox", "secret", "http://localhost:8080/api/auth/google/callback")
+	if err != nil {
+		t.Fatalf("Failed to discover mock provider: %v", err)
+	}
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+	handler.Providers["google"] = google
+	return handler, mock, provider
+}
+
+func TestGoogleLogin_CreatesUser(t *testing.T) {
+	handler, mock, provider := newGoogleHandler(t)
+	provider.SetUser(oidctest.User{Subject: "1098765", Email: "jane@gmail.com", EmailVerified: true, Name: "Jane"})
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM identities i JOIN users u")).
+		WithArgs("google", "1098765").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "url_photo", "role", "is_suspended", "totp_enabled"}))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users u WHERE lower(u.email) = lower($1)")).
+		WithArgs("jane@gmail.com", "google").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "url_photo", "role", "is_suspended", "totp_enabled", "linkable"}))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (name, email, url_photo, email_verified)")).
+		WithArgs("Jane", "jane@gmail.com", "", true).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow("3", "user"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO identities (user_id, provider, subject, email)")).
+		WithArgs("3", "google", "1098765", "jane@gmail.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO sessions")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := completeGoogleLogin(t, handler, "/api/auth/google/start")
+	rr := httptest.NewRecorder()
+	handler.OAuthCallbackHandler(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected redirect to the client, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	location := rr.Header().Get("Location")
+	if !strings.HasPrefix(location, "http://localhost:5173/oauth/callback#") || !strings.Contains(location, "refresh_token=") {
+		t.Errorf("Expected tokens in the client redirect, got %q", location)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestGoogleCallback_StateMismatch(t *testing.T) {
+	handler, _, _ := newGoogleHandler(t)
+
+	req := completeGoogleLogin(t, handler, "/api/auth/google/start")
+	q := req.URL.Query()
+	q.Set("state", "forged")
+	req.URL.RawQuery = q.Encode()
+
+	rr := httptest.NewRecorder()
+	handler.OAuthCallbackHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %d", rr.Code)
+	}
+}
+
+func TestGoogleLogin_LinksVerifiedEmail(t *testing.T) {
+	handler, mock, provider := newGoogleHandler(t)
+	provider.SetUser(oidctest.User{Subject: "1098765", Email: "jane@gmail.com", EmailVerified: true, Name: "Jane"})
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM identities i JOIN users u")).
+		WithArgs("google", "1098765").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "url_photo", "role", "is_suspended", "totp_enabled"}))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users u WHERE lower(u.email) = lower($1)")).
+		WithArgs("jane@gmail.com", "google").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "url_photo", "role", "is_suspended", "totp_enabled", "linkable"}).
+			AddRow("1", "Jane", "", "admin", false, false, true))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO identities (user_id, provider, subject, email)")).
+		WithArgs("1", "google", "1098765", "jane@gmail.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO sessions")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := completeGoogleLogin(t, handler, "/api/auth/google/start")
+	rr := httptest.NewRecorder()
+	handler.OAuthCallbackHandler(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected redirect to the client, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestGoogleLogin_RefusesUnverifiedEmailAccount(t *testing.T) {
+	handler, mock, provider := newGoogleHandler(t)
+	provider.SetUser(oidctest.User{Subject: "1098765", Email: "jane@gmail.com", EmailVerified: true, Name: "Jane"})
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM identities i JOIN users u")).
+		WithArgs("google", "1098765").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "url_photo", "role", "is_suspended", "totp_enabled"}))
+	// Someone signed up with Jane's address and never verified it
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users u WHERE lower(u.email) = lower($1)")).
+		WithArgs("jane@gmail.com", "google").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "url_photo", "role", "is_suspended", "totp_enabled", "linkable"}).
+			AddRow("1", "Jane", "", "user", false, false, false))
+
+	req := completeGoogleLogin(t, handler, "/api/auth/google/start")
+	rr := httptest.NewRecorder()
+	handler.OAuthCallbackHandler(rr, req)
+
+	if location := rr.Header().Get("Location"); location != "http://localhost:5173/login?oauth_error=account_exists" {
+		t.Errorf("Expected the login to be refused, got %d %q", rr.Code, location)
+	}
+
+	// No identity was linked and no session started
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestLinkIdentity_AttachesToLoggedInUser(t *testing.T) {
+	handler, mock, provider := newGoogleHandler(t)
+	provider.SetUser(oidctest.User{Subject: "1098765", Email: "other@gmail.com", EmailVerified: true, Name: "Jane"})
+
+	token, _ := utils.GenerateMockJWT("1", "other_key")
+	linkReq := httptest.NewRequest(http.MethodPost, "/api/users/identities/google", nil)
+	linkReq.SetPathValue("provider", "google")
+	linkReq.Header.Set("Authorization", "Bearer "+token)
+	linkRR := httptest.NewRecorder()
+	handler.LinkIdentityHandler(linkRR, linkReq)
+
+	if linkRR.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", linkRR.Code, linkRR.Body.String())
+	}
+
+	var link struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(linkRR.Body).Decode(&link); err != nil || !strings.HasPrefix(link.URL, "/api/auth/google/start?link=") {
+		t.Fatalf("Expected a start URL, got %q", link.URL)
+	}
+
+	req := completeGoogleLogin(t, handler, link.URL)
+	rr := httptest.NewRecorder()
+	handler.OAuthCallbackHandler(rr, req)
+
+	// Nothing is linked until the user who started confirms
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || location.Path != "/profile" {
+		t.Fatalf("Expected redirect to the profile, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	fragment, _ := url.ParseQuery(location.Fragment)
+	code := fragment.Get("link_code")
+	if code == "" || fragment.Get("link_provider") != "google" {
+		t.Fatalf("Expected a link code in the fragment, got %q", location.Fragment)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM identities WHERE provider = $1 AND subject = $2")).
+		WithArgs("google", "1098765").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO identities (user_id, provider, subject, email)")).
+		WithArgs("1", "google", "1098765", "other@gmail.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	confirmRR := confirmLink(handler, token, code)
+	if confirmRR.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d: %s", confirmRR.Code, confirmRR.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func confirmLink(handler *AuthHandler, token, code string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/users/identities/google/confirm", strings.NewReader(`{"code": "`+code+`"}`))
+	req.SetPathValue("provider", "google")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler.ConfirmLinkHandler(rr, req)
+	return rr
+}
+
+func TestLinkIdentity_OtherUserCannotConfirm(t *testing.T) {
+	handler, mock, provider := newGoogleHandler(t)
+	provider.SetUser(oidctest.User{Subject: "victim-sub", Email: "victim@gmail.com", EmailVerified: true, Name: "Victim"})
+
+	// The attacker starts linking for their own account and gets the victim to open the URL
+	attacker, _ := utils.GenerateMockJWT("1", "other_key")
+	linkReq := httptest.NewRequest(http.MethodPost, "/api/users/identities/google", nil)
+	linkReq.SetPathValue("provider", "google")
+	linkReq.Header.Set("Authorization", "Bearer "+attacker)
+	linkRR := httptest.NewRecorder()
+	handler.LinkIdentityHandler(linkRR, linkReq)
+
+	var link struct {
+		URL string `json:"url"`
+	}
+	json.NewDecoder(linkRR.Body).Decode(&link)
+
+	rr := httptest.NewRecorder()
+	handler.OAuthCallbackHandler(rr, completeGoogleLogin(t, handler, link.URL))
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+
+	// The victim's client confirms with the victim's session
+	victim, _ := utils.GenerateMockJWT("2", "other_key")
+	if confirmRR := confirmLink(handler, victim, fragment.Get("link_code")); confirmRR.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 Forbidden, got %d", confirmRR.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Expected no identity written: %v", err)
+	}
+}
+
+func TestOAuthStart_UnknownProvider(t *testing.T) {
+	handler, _, _ := newGoogleHandler(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/github/start", nil)
+	req.SetPathValue("provider", "github")
+	rr := httptest.NewRecorder()
+	handler.OAuthStartHandler(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 Not Found, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	errRefreshReused = errors.New("refresh token reuse")
+	errSuspended     = errors.New("account suspended")
+)
+
+// sessionUser holds what ends up in the access token claims
+type sessionUser struct {
+	ID       string
+	Name     string
+	URLPhoto string
+	Role     string
+}
+
+func (h *AuthHandler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := utils.HashToken(input.RefreshToken)
+
+	var sessionID, userID string
+	var used, expired, revoked bool
+	err := h.DB.QueryRowContext(r.Context(), `
+		SELECT rt.session_id, s.user_id, rt.used_at IS NOT NULL, rt.expires_at < NOW(), s.revoked_at IS NOT NULL
+		FROM refresh_tokens rt
+		JOIN sessions s ON s.id = rt.session_id
+		WHERE rt.token_hash = $1`, tokenHash).Scan(&sessionID, &userID, &used, &expired, &revoked)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+		return
+	}
+
+	if revoked || expired {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	// A refresh token is only good once, seeing it again means it leaked,
+	// so the whole session it belongs to is cut off
+	if used {
+		h.revokeSession(r.Context(), sessionID)
+		http.Error(w, "Refresh token reuse detected", http.StatusUnauthorized)
+		return
+	}
+
+	// Spending the old token and storing the new one commit together, a failure in between
+	// leaves the old token usable for the client's retry instead of tripping reuse detection
+	user := sessionUser{ID: userID}
+	var token, refreshToken string
+	err = db.InTx(r.Context(), h.DB, func(tx db.Querier) error {
+		res, err := tx.ExecContext(r.Context(), "UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL", tokenHash)
+		if err != nil {
+			return err
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			return errRefreshReused
+		}
+
+		var suspended bool
+		err = tx.QueryRowContext(r.Context(), "SELECT name, COALESCE(url_photo, ''), role, is_suspended FROM users WHERE id = $1", userID).
+			Scan(&user.Name, &user.URLPhoto, &user.Role, &suspended)
+		if err != nil {
+			return err
+		}
+		if suspended {
+			return errSuspended
+		}
+
+		token, refreshToken, err = h.issueTokens(r.Context(), tx, user, sessionID)
+		return err
+	})
+
+	switch {
+	case errors.Is(err, errRefreshReused):
+		h.revokeSession(r.Context(), sessionID)
+		http.Error(w, "Refresh token reuse detected", http.StatusUnauthorized)
+		return
+	case errors.Is(err, errSuspended):
+		h.revokeSession(r.Context(), sessionID)
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error refreshing token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token, "refresh_token": refreshToken})
+}
+
+func (h *AuthHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The session is taken from the access token, or from the refresh token
+	// when the access token already expired
+	if claims, err := utils.ParseClaims(r, h.SecretKey); err == nil {
+		if sessionID, ok := claims["sid"].(string); ok && sessionID != "" {
+			if err := h.revokeSession(r.Context(), sessionID); err != nil {
+				http.Error(w, "Error logging out", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Logged out"))
+			return
+		}
+	}
+
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.RefreshToken == "" {
+		http.Error(w, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := h.DB.ExecContext(r.Context(), `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = (SELECT session_id FROM refresh_tokens WHERE token_hash = $1) AND revoked_at IS NULL`,
+		utils.HashToken(input.RefreshToken),
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error logging out", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Logged out"))
+}
+
+// startSession opens a new session for the user and answers with its first token pair
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user sessionUser) {
+	sessionID, err := h.createSession(r, user.ID)
+	if err != nil {
+		http.Error(w, "Error creating session", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTokens(r.Context(), w, user, sessionID)
+}
+
+// writeTokens answers with a fresh token pair for the session
+func (h *AuthHandler) writeTokens(ctx context.Context, w http.ResponseWriter, user sessionUser, sessionID string) {
+	token, refreshToken, err := h.issueTokens(ctx, h.DB, user, sessionID)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token, "refresh_token": refreshToken})
+}
+
+func (h *AuthHandler) createSession(r *http.Request, userID string) (string, error) {
+	sessionID, err := utils.NewRandomToken(24)
+	if err != nil {
+		return "", err
+	}
+
+	userAgent := r.UserAgent()
+	_, err = h.DB.ExecContext(r.Context(),
+		"INSERT INTO sessions (id, user_id, user_agent, ip, device) VALUES ($1, $2, $3, $4, $5)",
+		sessionID, userID, userAgent, utils.ClientIP(r), utils.DeviceName(userAgent),
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		return "", err
+	}
+
+	return sessionID, nil
+}
+
+// issueTokens signs a new access token and rotates in a new refresh token for the session,
+// stored through q so a rotation can keep it in its transaction
+func (h *AuthHandler) issueTokens(ctx context.Context, q db.Querier, user sessionUser, sessionID string) (string, string, error) {
+	refreshToken, err := utils.NewRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = q.ExecContext(ctx,
+		"INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		sessionID, utils.HashToken(refreshToken), time.Now().Add(RefreshTokenTTL),
+	)
+	if err != nil {
+		logging.FromContext(ctx).Error("DB error", "err", err)
+		return "", "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":   user.ID,
+		"name":      user.Name,
+		"url_photo": user.URLPhoto,
+		"role":      user.Role,
+		"sid":       sessionID,
+		"exp":       time.Now().Add(AccessTokenTTL).Unix(),
+	})
+
+	tokenString, err := token.SignedString([]byte(h.SecretKey))
+	if err != nil {
+		return "", "", err
+	}
+
+	return tokenString, refreshToken, nil
+}
+
+func (h *AuthHandler) revokeSession(ctx context.Context, sessionID string) error {
+	_, err := h.DB.ExecContext(ctx, "UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", sessionID)
+	if err != nil {
+		logging.FromContext(ctx).Error("DB error", "err", err)
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestRefresh_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM refresh_tokens rt")).
+		WithArgs(utils.HashToken("old-refresh")).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "user_id", "used", "expired", "revoked"}).
+			AddRow("sess-1", "1", false, false, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL")).
+		WithArgs(utils.HashToken("old-refresh")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, COALESCE(url_photo, ''), role, is_suspended FROM users WHERE id = $1")).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "url_photo", "role", "is_suspended"}).
+			AddRow("Test User", "", "user", false))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens (session_id, token_hash, expires_at)")).
+		WithArgs("sess-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token": "old-refresh"}`))
+	rr := httptest.NewRecorder()
+	handler.RefreshHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d", rr.Code)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if resp["token"] == "" || resp["refresh_token"] == "" || resp["refresh_token"] == "old-refresh" {
+		t.Errorf("Expected a new token pair, got: %v", resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRefresh_FailedRotationKeepsToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM refresh_tokens rt")).
+		WithArgs(utils.HashToken("old-refresh")).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "user_id", "used", "expired", "revoked"}).
+			AddRow("sess-1", "1", false, false, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_tokens SET used_at = NOW()")).
+		WithArgs(utils.HashToken("old-refresh")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, COALESCE(url_photo, ''), role, is_suspended FROM users WHERE id = $1")).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "url_photo", "role", "is_suspended"}).
+			AddRow("Test User", "", "user", false))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens (session_id, token_hash, expires_at)")).
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token": "old-refresh"}`))
+	rr := httptest.NewRecorder()
+	handler.RefreshHandler(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", rr.Code)
+	}
+
+	// Rolled back, and the session was not revoked
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRefresh_ReuseRevokesSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM refresh_tokens rt")).
+		WithArgs(utils.HashToken("stolen")).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "user_id", "used", "expired", "revoked"}).
+			AddRow("sess-1", "1", true, false, false))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL")).
+		WithArgs("sess-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", strings.NewReader(`{"refresh_token": "stolen"}`))
+	rr := httptest.NewRecorder()
+	handler.RefreshHandler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 Unauthorized, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestLogout_RefreshToken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at = NOW()")).
+		WithArgs(utils.HashToken("some-refresh")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", strings.NewReader(`{"refresh_token": "some-refresh"}`))
+	rr := httptest.NewRecorder()
+	handler.LogoutHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
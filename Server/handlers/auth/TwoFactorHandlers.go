@@ -0,0 +1,435 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/metrics"
+	"github.com/Zheng5005/BiteBox/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	TOTPIssuer        = "BiteBox"
+	RecoveryCodeCount = 10
+	// MFAChallengeTTL is how long the user has to type the second factor after the password
+	MFAChallengeTTL = 5 * time.Minute
+	// MFAChallengeAttempts is how many codes one challenge takes before the password is needed again
+	MFAChallengeAttempts = 3
+)
+
+// EnrollTOTPHandler stores a new pending secret, it only takes effect once a code from it is verified
+func (h *AuthHandler) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var email string
+	var enabled bool
+	err = h.DB.QueryRowContext(r.Context(), "SELECT email, totp_enabled FROM users WHERE id = $1", userID).Scan(&email, &enabled)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error loading user", http.StatusInternalServerError)
+		return
+	}
+
+	if enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(), "UPDATE users SET totp_secret = $1 WHERE id = $2 AND totp_enabled = false", secret, userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error saving secret", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(TOTPIssuer, email, secret),
+	})
+}
+
+// VerifyTOTPHandler turns 2FA on once the user proves their app has the secret, and hands out the recovery codes
+func (h *AuthHandler) VerifyTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	err = h.DB.QueryRowContext(r.Context(), "SELECT totp_secret, totp_enabled FROM users WHERE id = $1", userID).Scan(&secret, &enabled)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error loading user", http.StatusInternalServerError)
+		return
+	}
+
+	if enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+
+	if !secret.Valid {
+		http.Error(w, "Start the enrollment first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(secret.String, input.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(),
+		"UPDATE users SET totp_enabled = true, totp_last_step = $2 WHERE id = $1 AND totp_enabled = false AND totp_secret = $3",
+		userID, step, secret.String,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error enabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "Enrollment changed, start again", http.StatusConflict)
+		return
+	}
+
+	codes, err := h.replaceRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+func (h *AuthHandler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	err = h.DB.QueryRowContext(r.Context(), "SELECT totp_secret, totp_enabled FROM users WHERE id = $1", userID).Scan(&secret, &enabled)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error loading user", http.StatusInternalServerError)
+		return
+	}
+
+	if !enabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+
+	// A stolen access token alone isn't enough to turn 2FA off
+	ok, err := h.checkSecondFactor(r.Context(), userID, secret.String, input.Code)
+	if err != nil {
+		http.Error(w, "Error checking code", http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	_, err = h.DB.ExecContext(r.Context(), "UPDATE users SET totp_enabled = false, totp_secret = NULL, totp_last_step = NULL WHERE id = $1", userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error disabling two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Two-factor authentication disabled"))
+}
+
+// LoginTwoFactorHandler is the second step of LoginHandler, it trades the challenge token and a code for a session
+func (h *AuthHandler) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.ChallengeToken == "" || input.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	userID, challengeID, err := h.parseChallengeToken(input.ChallengeToken)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Wrong codes lock the account like wrong passwords do, the challenge only saves retyping the password
+	wait, err := h.loginLockedFor(r, userKey(userID))
+	if err != nil {
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		return
+	}
+
+	if wait > 0 {
+		metrics.Logins.WithLabelValues(metrics.LoginLockedOut).Inc()
+		writeLockedOut(w, wait)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(),
+		"UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1 AND user_id = $2 AND attempts < $3 AND expires_at > NOW()",
+		challengeID, userID, MFAChallengeAttempts,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error logging in", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	user := sessionUser{ID: userID}
+	var secret sql.NullString
+	var enabled, suspended bool
+	err = h.DB.QueryRowContext(r.Context(),
+		"SELECT name, COALESCE(url_photo, ''), role, is_suspended, totp_secret, totp_enabled FROM users WHERE id = $1", userID,
+	).Scan(&user.Name, &user.URLPhoto, &user.Role, &suspended, &secret, &enabled)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error loading user", http.StatusInternalServerError)
+		return
+	}
+
+	if !enabled {
+		http.Error(w, "Invalid challenge", http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := h.checkSecondFactor(r.Context(), userID, secret.String, input.Code)
+	if err != nil {
+		http.Error(w, "Error checking code", http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		h.recordLoginFailure(r, userKey(userID))
+		metrics.Logins.WithLabelValues(metrics.LoginFailed).Inc()
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	h.clearLoginFailures(r.Context(), userKey(userID))
+	if _, err := h.DB.ExecContext(r.Context(), "DELETE FROM mfa_challenges WHERE id = $1", challengeID); err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+	}
+
+	if suspended {
+		http.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	metrics.Logins.WithLabelValues(metrics.LoginSucceeded).Inc()
+	h.startSession(w, r, user)
+}
+
+// writeChallenge answers a correct password when the account still needs a second factor
+func (h *AuthHandler) writeChallenge(w http.ResponseWriter, r *http.Request, userID string) {
+	challenge, err := h.challengeToken(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"mfa_required":    true,
+		"challenge_token": challenge,
+	})
+}
+
+// challengeToken stores a new challenge, which counts the codes tried against it, and signs a token for it
+func (h *AuthHandler) challengeToken(ctx context.Context, userID string) (string, error) {
+	challengeID, err := utils.NewRandomToken(24)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(MFAChallengeTTL)
+	_, err = h.DB.ExecContext(ctx,
+		"INSERT INTO mfa_challenges (id, user_id, expires_at) VALUES ($1, $2, $3)",
+		challengeID, userID, expiresAt,
+	)
+	if err != nil {
+		logging.FromContext(ctx).Error("DB error", "err", err)
+		return "", err
+	}
+
+	return h.signChallenge(userID, challengeID, expiresAt)
+}
+
+// signChallenge has no user_id or sid claim, so the token can't be used as an access token
+func (h *AuthHandler) signChallenge(userID, challengeID string, expiresAt time.Time) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"mfa_user_id": userID,
+		"jti":         challengeID,
+		"exp":         expiresAt.Unix(),
+	}).SignedString([]byte(h.SecretKey))
+}
+
+// parseChallengeToken returns the user and the stored challenge the token stands for
+func (h *AuthHandler) parseChallengeToken(challenge string) (string, string, error) {
+	token, err := jwt.Parse(challenge, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Invalid method")
+		}
+		return []byte(h.SecretKey), nil
+	})
+	if err != nil || !token.Valid {
+		return "", "", fmt.Errorf("Invalid or expired challenge")
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	userID, _ := claims["mfa_user_id"].(string)
+	challengeID, _ := claims["jti"].(string)
+	if userID == "" || challengeID == "" {
+		return "", "", fmt.Errorf("Invalid or expired challenge")
+	}
+
+	return userID, challengeID, nil
+}
+
+// checkSecondFactor accepts a TOTP code or an unused recovery code, each only once
+func (h *AuthHandler) checkSecondFactor(ctx context.Context, userID, secret, code string) (bool, error) {
+	if step, ok := utils.ValidateTOTP(secret, code, time.Now()); ok {
+		res, err := h.DB.ExecContext(ctx,
+			"UPDATE users SET totp_last_step = $2 WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)",
+			userID, step,
+		)
+		if err != nil {
+			logging.FromContext(ctx).Error("DB error", "err", err)
+			return false, err
+		}
+		count, _ := res.RowsAffected()
+		return count > 0, nil
+	}
+
+	res, err := h.DB.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, utils.HashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		logging.FromContext(ctx).Error("DB error", "err", err)
+		return false, err
+	}
+	count, _ := res.RowsAffected()
+	return count > 0, nil
+}
+
+// replaceRecoveryCodes drops the user's old codes and returns fresh ones, only their hashes are kept
+func (h *AuthHandler) replaceRecoveryCodes(ctx context.Context, userID string) ([]string, error) {
+	if _, err := h.DB.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		logging.FromContext(ctx).Error("DB error", "err", err)
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+
+		_, err := h.DB.ExecContext(ctx,
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, utils.HashToken(normalizeRecoveryCode(codes[i])),
+		)
+		if err != nil {
+			logging.FromContext(ctx).Error("DB error", "err", err)
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func testChallenge(handler *AuthHandler) string {
+	challenge, _ := handler.signChallenge("1", "chal-1", time.Now().Add(MFAChallengeTTL))
+	return challenge
+}
+
+// expectChallengeAttempt is the lockout check and the attempt taken from the challenge
+func expectChallengeAttempt(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT locked_until FROM login_failures")).
+		WithArgs("user:1", "ip:192.0.2.1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE mfa_challenges SET attempts = attempts + 1")).
+		WithArgs("chal-1", "1", MFAChallengeAttempts).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectChallengeCompleted(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM login_failures WHERE key = $1")).
+		WithArgs("user:1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mfa_challenges WHERE id = $1")).
+		WithArgs("chal-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestLogin_RequiresSecondFactor(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("123"), bcrypt.DefaultCost)
+
+	expectNotLockedOut(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE lower(email) = lower($1)")).
+		WithArgs("jd@gmail.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "name", "url_photo", "role", "is_suspended", "totp_enabled"}).
+			AddRow("1", string(hashed), "Test User", "", "user", false, true))
+	expectFailuresCleared(mock)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mfa_challenges (id, user_id, expires_at)")).
+		WithArgs(sqlmock.AnyArg(), "1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := `{"email": "jd@gmail.com", "password": "123"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.LoginHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	var resp map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if resp["mfa_required"] != true || resp["challenge_token"] == "" {
+		t.Fatalf("Expected a 2FA challenge, got %v", resp)
+	}
+
+	if _, ok := resp["token"]; ok {
+		t.Errorf("Expected no access token before the second factor")
+	}
+
+	// The challenge must not work as an access token
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+resp["challenge_token"].(string))
+	if _, err := utils.ParseToken(r, "other_key"); err == nil {
+		t.Errorf("Expected challenge token to be rejected as an access token")
+	}
+}
+
+func TestLoginTwoFactor_TOTP(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+	challenge := testChallenge(handler)
+	step := utils.TOTPStep(time.Now())
+	code, _ := utils.TOTPCode(testTOTPSecret, step)
+
+	expectChallengeAttempt(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("totp_secret, totp_enabled FROM users WHERE id = $1")).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "url_photo", "role", "is_suspended", "totp_secret", "totp_enabled"}).
+			AddRow("Test User", "", "user", false, testTOTPSecret, true))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET totp_last_step = $2")).
+		WithArgs("1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectChallengeCompleted(mock)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO sessions")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := `{"challenge_token": "` + challenge + `", "code": "` + code + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.LoginTwoFactorHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestLoginTwoFactor_ReplayedCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+	challenge := testChallenge(handler)
+	code, _ := utils.TOTPCode(testTOTPSecret, utils.TOTPStep(time.Now()))
+
+	expectChallengeAttempt(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("totp_secret, totp_enabled FROM users WHERE id = $1")).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "url_photo", "role", "is_suspended", "totp_secret", "totp_enabled"}).
+			AddRow("Test User", "", "user", false, testTOTPSecret, true))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET totp_last_step = $2")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO login_failures")).
+		WithArgs("user:1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO login_failures")).
+		WithArgs("ip:192.0.2.1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(1))
+
+	body := `{"challenge_token": "` + challenge + `", "code": "` + code + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.LoginTwoFactorHandler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 Unauthorized, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestLoginTwoFactor_LockedOut(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT locked_until FROM login_failures")).
+		WithArgs("user:1", "ip:192.0.2.1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(time.Now().Add(time.Minute)))
+
+	body := `{"challenge_token": "` + testChallenge(handler) + `", "code": "123456"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.LoginTwoFactorHandler(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 Too Many Requests, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestLoginTwoFactor_ChallengeUsedUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT locked_until FROM login_failures")).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE mfa_challenges SET attempts = attempts + 1")).
+		WithArgs("chal-1", "1", MFAChallengeAttempts).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	body := `{"challenge_token": "` + testChallenge(handler) + `", "code": "123456"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.LoginTwoFactorHandler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 Unauthorized, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestLoginTwoFactor_RecoveryCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+	challenge := testChallenge(handler)
+
+	expectChallengeAttempt(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("totp_secret, totp_enabled FROM users WHERE id = $1")).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "url_photo", "role", "is_suspended", "totp_secret", "totp_enabled"}).
+			AddRow("Test User", "", "user", false, testTOTPSecret, true))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recovery_codes SET used_at = NOW()")).
+		WithArgs("1", utils.HashToken("a1b2c3d4e5")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectChallengeCompleted(mock)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO sessions")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO refresh_tokens")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := `{"challenge_token": "` + challenge + `", "code": "A1B2C-3D4E5"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.LoginTwoFactorHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestVerifyTOTP_EnablesAndReturnsRecoveryCodes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewAuthHandler(db, "other_key", lib.NewLogMailer(io.Discard))
+	token, _ := utils.GenerateMockJWT("1", "other_key")
+	code, _ := utils.TOTPCode(testTOTPSecret, utils.TOTPStep(time.Now()))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT totp_secret, totp_enabled FROM users WHERE id = $1")).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_enabled"}).AddRow(testTOTPSecret, false))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET totp_enabled = true")).
+		WithArgs("1", sqlmock.AnyArg(), testTOTPSecret).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM recovery_codes WHERE user_id = $1")).
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < RecoveryCodeCount; i++ {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO recovery_codes (user_id, code_hash)")).
+			WithArgs("1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/2fa/verify", strings.NewReader(`{"code": "`+code+`"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler.VerifyTOTPHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp map[string][]string
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(resp["recovery_codes"]) != RecoveryCodeCount {
+		t.Errorf("Expected %d recovery codes, got %v", RecoveryCodeCount, resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
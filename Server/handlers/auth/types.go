@@ -1,6 +1,12 @@
 package auth
 
-import "github.com/Zheng5005/BiteBox/db"
+import (
+	"context"
+	"sync"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/lib"
+)
 
 type User struct {
 	ID   string `json:"id"`
@@ -8,14 +14,38 @@ type User struct {
 	Email string `json:"email"`
 	Password string `json:"password"`
 	URLPhoto string `json:"url_photo"`
-	GoogleID string `json:"google_id"`
 }
 
 type AuthHandler struct {
 	DB db.DBExecutor
 	SecretKey string
+	Mailer lib.Mailer
+	// Images stores profile photos uploaded at sign up
+	Images lib.ImageStore
+	// AppURL is the client base URL used to build the links sent by email
+	AppURL string
+	// Providers holds the configured external login providers by name, e.g. "google"
+	Providers map[string]*lib.OIDCProvider
+
+	background sync.WaitGroup
+}
+
+// inBackground runs fn after the request is answered. ctx keeps the request's logger and
+// trace but is not cancelled with it.
+func (h *AuthHandler) inBackground(ctx context.Context, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		fn(ctx)
+	}()
+}
+
+// Wait blocks until work started in the background, such as mail, is done. Called on shutdown.
+func (h *AuthHandler) Wait() {
+	h.background.Wait()
 }
 
-func NewAuthHandler(db db.DBExecutor, secret string) *AuthHandler {
-	return &AuthHandler{DB: db, SecretKey: secret}
+func NewAuthHandler(db db.DBExecutor, secret string, mailer lib.Mailer) *AuthHandler {
+	return &AuthHandler{DB: db, SecretKey: secret, Mailer: mailer, AppURL: "http://localhost:5173", Providers: map[string]*lib.OIDCProvider{}}
 }
@@ -2,10 +2,13 @@ package comments
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/Zheng5005/BiteBox/events"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/metrics"
 	"github.com/Zheng5005/BiteBox/utils"
 )
 
@@ -22,7 +25,7 @@ func (h *CommentHandler) CommentsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	rows, err := h.DB.Query("SELECT c.id, u.name, c.recipe_id, c.comment, c.rating FROM comments c JOIN users u ON u.id = c.user_id WHERE recipe_id = $1", id) 	
+	rows, err := h.DB.QueryContext(r.Context(), "SELECT c.id, COALESCE(u.name, 'Deleted user'), c.recipe_id, c.comment, c.rating FROM comments c LEFT JOIN users u ON u.id = c.user_id WHERE recipe_id = $1 AND c.is_hidden = false", id) 	
 	if err != nil {
 		http.Error(w, "Query error", http.StatusInternalServerError)
 	}
@@ -74,16 +77,34 @@ func (h *CommentHandler) PostComment(w http.ResponseWriter, r *http.Request)  {
 		return
 	}
 
-	_, err = h.DB.Exec(
-		"INSERT INTO comments (user_id, recipe_id, comment, rating) VALUES ($1, $2, $3, $4)",
+	var commentID string
+	err = h.DB.QueryRowContext(r.Context(),
+		"INSERT INTO comments (user_id, recipe_id, comment, rating) VALUES ($1, $2, $3, $4) RETURNING id",
 		userID, id, input.Comment, input.Rating,
-	)
+	).Scan(&commentID)
 	if err != nil {
-		log.Println("DB error", err)
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
 		http.Error(w, "Error creating a comment", http.StatusInternalServerError)
 		return
 	}
 
+	// A rating without text is reported as a rating only
+	event := events.Event{
+		Type:       events.CommentCreated,
+		ActorID:    userID,
+		TargetType: "recipe",
+		TargetID:   id,
+		Data: map[string]string{
+			"comment_id": commentID,
+			"rating":     strconv.FormatFloat(float64(input.Rating), 'f', -1, 32),
+		},
+	}
+	if strings.TrimSpace(input.Comment) == "" {
+		event.Type = events.RecipeRated
+	}
+	h.Events.Publish(r.Context(), event)
+	metrics.CommentsPosted.Inc()
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Comment created"))
 }
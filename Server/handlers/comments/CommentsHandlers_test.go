@@ -21,9 +21,9 @@ func TestPostComment_Success(t *testing.T) {
 	defer db.Close()
 
 	//Expect the INSERT query
-	mock.ExpectExec(`INSERT INTO comments \(user_id, recipe_id, comment, rating\)`).
+	mock.ExpectQuery(`INSERT INTO comments \(user_id, recipe_id, comment, rating\)`).
 		WithArgs("user-abc", "1", "Nice recipe!", 4.5).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
 
 	// Initializing handler with mock DB
 	handler := NewCommentHandler(db, "other_key")
@@ -80,7 +80,7 @@ func TestGetComment_Success(t *testing.T) {
 	// expected rows
 	rows := sqlmock.NewRows([]string{"id", "name", "recipe_id", "comment", "rating"}).AddRow("1", "Alice", "1", "Great recipe!", "5").AddRow("2", "Bob", "1", "Too spicy!", "4")
 
-	mock.ExpectQuery("SELECT c.id, u.name, c.recipe_id, c.comment, c.rating").
+	mock.ExpectQuery(`SELECT c.id, COALESCE\(u.name, 'Deleted user'\), c.recipe_id, c.comment, c.rating`).
 		WithArgs("1").
 		WillReturnRows(rows)
 
@@ -2,6 +2,7 @@ package comments
 
 import (
 	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/events"
 )
 
 type Comment struct {
@@ -15,6 +16,8 @@ type Comment struct {
 type CommentHandler struct {
 	DB db.DBExecutor
 	SecretKey string
+	// Events is told about new comments and ratings, may be nil
+	Events *events.Dispatcher
 }
 
 func NewCommentHandler(db db.DBExecutor, secret string) *CommentHandler {
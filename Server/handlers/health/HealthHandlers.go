@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/lib/pq"
+)
+
+// Healthz answers as long as the process serves requests, it checks nothing else
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// Readyz reports whether the server can take traffic: the database answers with the expected
+// schema and the image storage is reachable. It responds 503 while any check fails.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ready := Readiness{Status: "ok", Checks: map[string]string{}}
+
+	check := func(name string, fn func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(r.Context(), CheckTimeout)
+		defer cancel()
+
+		if err := fn(ctx); err != nil {
+			logging.FromContext(r.Context()).Warn("Readiness check failed", "check", name, "err", err)
+			ready.Status = "unavailable"
+			ready.Checks[name] = err.Error()
+			return
+		}
+		ready.Checks[name] = "ok"
+	}
+
+	check("database", h.DB.PingContext)
+	if ready.Checks["database"] == "ok" {
+		check("migrations", h.checkMigrations)
+	}
+	if pinger, ok := h.Storage.(lib.Pinger); ok {
+		check("storage", func(ctx context.Context) error { return h.checkStorage(ctx, pinger) })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if ready.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(ready)
+}
+
+// undefinedTable is PostgreSQL's error code for a missing relation
+const undefinedTable = "42P01"
+
+// checkStorage pings the storage at most once per StorageCheckTTL, or per StorageRetryInterval
+// while it is failing
+func (h *HealthHandler) checkStorage(ctx context.Context, pinger lib.Pinger) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ttl := StorageCheckTTL
+	if h.storageErr != nil {
+		ttl = StorageRetryInterval
+	}
+	if !h.storageCheckAt.IsZero() && time.Since(h.storageCheckAt) < ttl {
+		return h.storageErr
+	}
+
+	h.storageErr = pinger.Ping(ctx)
+	h.storageCheckAt = time.Now()
+	return h.storageErr
+}
+
+func (h *HealthHandler) checkMigrations(ctx context.Context) error {
+	var version int
+	err := h.DB.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == undefinedTable {
+		return errors.New("schema_migrations is missing, the database predates schema versioning: apply db/upgrade.sql")
+	} else if err != nil {
+		return err
+	}
+
+	if version < db.SchemaVersion {
+		return fmt.Errorf("schema version %d, expected %d", version, db.SchemaVersion)
+	}
+	return nil
+}
+
+func (h *HealthHandler) Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildVersion())
+}
+
+func buildVersion() Version {
+	v := Version{Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if v.Commit == "" {
+					v.Commit = setting.Value
+				}
+			case "vcs.time":
+				if v.BuildTime == "" {
+					v.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				v.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	if v.Commit == "" {
+		v.Commit = "unknown"
+	}
+	return v
+}
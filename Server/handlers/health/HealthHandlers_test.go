@@ -0,0 +1,172 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/lib/pq"
+)
+
+func readyz(t *testing.T, handler *HealthHandler) (int, Readiness) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handler.Readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var got Readiness
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	return rr.Code, got
+}
+
+func TestReadyz_AllChecksPass(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	mock.ExpectPing()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(db.SchemaVersion))
+
+	store, err := lib.NewLocalStore(t.TempDir(), "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	code, got := readyz(t, NewHealthHandler(mockDB, store))
+
+	if code != http.StatusOK || got.Status != "ok" {
+		t.Errorf("Expected ready, got %d %+v", code, got)
+	}
+	for _, name := range []string{"database", "migrations", "storage"} {
+		if got.Checks[name] != "ok" {
+			t.Errorf("Expected %s check ok, got %q", name, got.Checks[name])
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestReadyz_SchemaBehind(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	mock.ExpectPing()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(db.SchemaVersion - 1))
+
+	code, got := readyz(t, NewHealthHandler(mockDB, nil))
+
+	if code != http.StatusServiceUnavailable || got.Checks["migrations"] == "ok" {
+		t.Errorf("Expected unavailable on an old schema, got %d %+v", code, got)
+	}
+}
+
+func TestReadyz_SchemaUnversioned(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	mock.ExpectPing()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM schema_migrations")).
+		WillReturnError(&pq.Error{Code: "42P01", Message: `relation "schema_migrations" does not exist`})
+
+	code, got := readyz(t, NewHealthHandler(mockDB, nil))
+
+	if code != http.StatusServiceUnavailable || !strings.Contains(got.Checks["migrations"], "db/upgrade.sql") {
+		t.Errorf("Expected the upgrade path reported, got %d %+v", code, got)
+	}
+}
+
+// countingStore is an image store whose pings are counted
+type countingStore struct {
+	lib.ImageStore
+	pings int
+	err   error
+}
+
+func (s *countingStore) Ping(ctx context.Context) error {
+	s.pings++
+	return s.err
+}
+
+func TestReadyz_StorageCheckIsCached(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	store := &countingStore{}
+	handler := NewHealthHandler(mockDB, store)
+
+	for i := 0; i < 3; i++ {
+		mock.ExpectPing()
+		mock.ExpectQuery(regexp.QuoteMeta("FROM schema_migrations")).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(db.SchemaVersion))
+
+		if code, got := readyz(t, handler); code != http.StatusOK {
+			t.Fatalf("Expected ready, got %d %+v", code, got)
+		}
+	}
+
+	if store.pings != 1 {
+		t.Errorf("Expected one storage ping across probes, got %d", store.pings)
+	}
+}
+
+func TestReadyz_DatabaseDown(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+	code, got := readyz(t, NewHealthHandler(mockDB, nil))
+
+	if code != http.StatusServiceUnavailable || got.Checks["database"] != "connection refused" {
+		t.Errorf("Expected the database failure reported, got %d %+v", code, got)
+	}
+
+	// The schema is not checked without a connection
+	if _, ok := got.Checks["migrations"]; ok {
+		t.Errorf("Expected no migrations check, got %+v", got.Checks)
+	}
+}
+
+func TestVersion_LinkerValuesWin(t *testing.T) {
+	Commit, BuildTime = "abc123", "2026-01-02T03:04:05Z"
+	defer func() { Commit, BuildTime = "", "" }()
+
+	rr := httptest.NewRecorder()
+	NewHealthHandler(nil, nil).Version(rr, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	var got Version
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+
+	if got.Commit != "abc123" || got.BuildTime != "2026-01-02T03:04:05Z" || got.GoVersion == "" {
+		t.Errorf("Unexpected version %+v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/lib"
+)
+
+// CheckTimeout bounds each readiness check, probes run every few seconds
+const CheckTimeout = 2 * time.Second
+
+// Storage pings can count against the backend's API quota (Cloudinary's Admin API is limited
+// per hour), so a result is reused for a while instead of pinging on every probe.
+// Failures are retried sooner so readiness recovers quickly.
+const (
+	StorageCheckTTL      = 5 * time.Minute
+	StorageRetryInterval = 30 * time.Second
+)
+
+// Set at build time, e.g. go build -ldflags "-X github.com/Zheng5005/BiteBox/handlers/health.Commit=$(git rev-parse HEAD)".
+// Without them the VCS stamp Go adds to builds from a checkout is used, with the commit time as build time.
+var (
+	Commit    string
+	BuildTime string
+)
+
+// Database is what readiness needs from the pool, *sql.DB satisfies it
+type Database interface {
+	PingContext(ctx context.Context) error
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type Readiness struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type Version struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+type HealthHandler struct {
+	DB Database
+	// Storage is checked when the backend implements lib.Pinger
+	Storage lib.ImageStore
+
+	mu             sync.Mutex
+	storageErr     error
+	storageCheckAt time.Time
+}
+
+func NewHealthHandler(db Database, storage lib.ImageStore) *HealthHandler {
+	return &HealthHandler{DB: db, Storage: storage}
+}
@@ -12,8 +12,16 @@ type MealsType struct {
 	Name string `json:"name"`
 }
 
-func MealsHandler(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.DB.Query("SELECT id, name FROM meal_type")
+type MealHandler struct {
+	DB db.DBExecutor
+}
+
+func NewMealHandler(db db.DBExecutor) *MealHandler {
+	return &MealHandler{DB: db}
+}
+
+func (h *MealHandler) MealsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.QueryContext(r.Context(), "SELECT id, name FROM meal_type")
 	if err != nil {
 		http.Error(w, "Query error", http.StatusInternalServerError)
 		return
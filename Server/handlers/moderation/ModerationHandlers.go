@@ -0,0 +1,340 @@
+package moderation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/events"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func (h *ModerationHandler) ReportContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		TargetType string `json:"target_type"`
+		TargetID   string `json:"target_id"`
+		Reason     string `json:"reason"`
+		Details    string `json:"details"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if input.TargetID == "" || !reasons[input.Reason] {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	var existsQuery string
+	switch input.TargetType {
+	case TargetRecipe:
+		existsQuery = "SELECT EXISTS (SELECT 1 FROM recipes WHERE id = $1)"
+	case TargetComment:
+		existsQuery = "SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1)"
+	default:
+		http.Error(w, "Invalid target type", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	if err := h.DB.QueryRowContext(r.Context(), existsQuery, input.TargetID).Scan(&exists); err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error creating report", http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		http.Error(w, "Reported content not found", http.StatusNotFound)
+		return
+	}
+
+	// A user can only have one open report per piece of content
+	_, err = h.DB.ExecContext(r.Context(), `
+		INSERT INTO reports (reporter_id, target_type, target_id, reason, details)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (reporter_id, target_type, target_id) WHERE status = 'open' DO NOTHING`,
+		userID, input.TargetType, input.TargetID, input.Reason, input.Details,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error creating report", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("Report created"))
+}
+
+func (h *ModerationHandler) ReportsQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT
+			rp.id,
+			rp.target_type,
+			rp.target_id,
+			rp.reason,
+			COALESCE(rp.details, ''),
+			COALESCE(reporter.name, ''),
+			rp.created_at,
+			COALESCE(CASE rp.target_type WHEN 'recipe' THEN r.name_recipe ELSE c.comment END, ''),
+			COALESCE(CAST(COALESCE(r.user_id, c.user_id) AS text), ''),
+			COALESCE(author.name, r.guest_name, '')
+		FROM reports rp
+		LEFT JOIN users reporter ON reporter.id = rp.reporter_id
+		LEFT JOIN recipes r ON rp.target_type = 'recipe' AND r.id = rp.target_id
+		LEFT JOIN comments c ON rp.target_type = 'comment' AND c.id = rp.target_id
+		LEFT JOIN users author ON author.id = COALESCE(r.user_id, c.user_id)
+		WHERE rp.status = $1
+		ORDER BY rp.created_at`, status)
+	if err != nil {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var queue []QueueItem
+
+	for rows.Next() {
+		var q QueueItem
+		if err := rows.Scan(&q.ID, &q.TargetType, &q.TargetID, &q.Reason, &q.Details, &q.ReporterName, &q.CreatedAt, &q.Content, &q.AuthorID, &q.AuthorName); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		queue = append(queue, q)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// Reasons TakeAction turns a report down
+var (
+	errReportResolved   = errors.New("report already resolved")
+	errNotHideable      = errors.New("only comments can be hidden")
+	errNotDeactivatable = errors.New("only recipes can be deactivated")
+	errNoAuthor         = errors.New("content has no registered author")
+)
+
+func (h *ModerationHandler) TakeAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reportID := strings.TrimPrefix(r.URL.Path, "/api/moderation/action/")
+	if reportID == "" {
+		http.Error(w, "Missing report ID", http.StatusBadRequest)
+		return
+	}
+
+	moderatorID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	switch input.Action {
+	case ActionDismiss, ActionHideContent, ActionDeactivateRecipe, ActionSuspendUser:
+	default:
+		http.Error(w, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	// The action, the reports it settles and the audit entry land together or not at all
+	var targetType, targetID, authorID string
+	err = db.InTx(r.Context(), h.DB, func(tx db.Querier) error {
+		var status string
+		err := tx.QueryRowContext(r.Context(), "SELECT target_type, target_id, status FROM reports WHERE id = $1 FOR UPDATE", reportID).Scan(&targetType, &targetID, &status)
+		if err != nil {
+			return err
+		}
+
+		if status != "open" {
+			return errReportResolved
+		}
+
+		authorID, err = authorOf(r.Context(), tx, targetType, targetID)
+		if err != nil {
+			return err
+		}
+
+		switch input.Action {
+		case ActionDismiss:
+			_, err = tx.ExecContext(r.Context(),
+				"UPDATE reports SET status = 'dismissed', resolved_at = NOW(), resolved_by = $1 WHERE id = $2",
+				moderatorID, reportID,
+			)
+		case ActionHideContent:
+			if targetType != TargetComment {
+				return errNotHideable
+			}
+			_, err = tx.ExecContext(r.Context(), "UPDATE comments SET is_hidden = true WHERE id = $1", targetID)
+		case ActionDeactivateRecipe:
+			if targetType != TargetRecipe {
+				return errNotDeactivatable
+			}
+			_, err = tx.ExecContext(r.Context(), "UPDATE recipes SET is_active = false WHERE id = $1", targetID)
+		case ActionSuspendUser:
+			if authorID == "" {
+				return errNoAuthor
+			}
+			_, err = tx.ExecContext(r.Context(), "UPDATE users SET is_suspended = true WHERE id = $1", authorID)
+			if err == nil {
+				_, err = tx.ExecContext(r.Context(), "UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", authorID)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		// Acting on the content settles every open report about it
+		if input.Action != ActionDismiss {
+			_, err = tx.ExecContext(r.Context(), `
+				UPDATE reports SET status = 'actioned', resolved_at = NOW(), resolved_by = $1
+				WHERE target_type = $2 AND target_id = $3 AND status = 'open'`,
+				moderatorID, targetType, targetID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(r.Context(), `
+			INSERT INTO moderation_actions (moderator_id, report_id, action, target_type, target_id, target_user_id, note)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::integer, $7)`,
+			moderatorID, reportID, input.Action, targetType, targetID, authorID, input.Note,
+		)
+		return err
+	})
+
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errReportResolved):
+		http.Error(w, "Report already resolved", http.StatusConflict)
+		return
+	case errors.Is(err, errNotHideable):
+		http.Error(w, "Only comments can be hidden, use deactivate_recipe for recipes", http.StatusBadRequest)
+		return
+	case errors.Is(err, errNotDeactivatable):
+		http.Error(w, "Only recipes can be deactivated", http.StatusBadRequest)
+		return
+	case errors.Is(err, errNoAuthor):
+		http.Error(w, "Content has no registered author", http.StatusBadRequest)
+		return
+	case err != nil:
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error applying action", http.StatusInternalServerError)
+		return
+	}
+
+	// Moderators stay anonymous to the author, so the event has no actor
+	if input.Action != ActionDismiss && authorID != "" {
+		h.Events.Publish(r.Context(), events.Event{
+			Type:       events.ContentModerated,
+			TargetType: targetType,
+			TargetID:   targetID,
+			Data:       map[string]string{"author_id": authorID, "action": input.Action},
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Action recorded"))
+}
+
+func (h *ModerationHandler) AuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT
+			a.id,
+			COALESCE(u.name, ''),
+			COALESCE(CAST(a.report_id AS text), ''),
+			a.action,
+			a.target_type,
+			a.target_id,
+			COALESCE(CAST(a.target_user_id AS text), ''),
+			COALESCE(a.note, ''),
+			a.created_at
+		FROM moderation_actions a
+		LEFT JOIN users u ON u.id = a.moderator_id
+		ORDER BY a.created_at DESC
+		LIMIT 100`)
+	if err != nil {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.ModeratorName, &e.ReportID, &e.Action, &e.TargetType, &e.TargetID, &e.TargetUserID, &e.Note, &e.CreatedAt); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// authorOf returns the registered user behind a recipe or comment, empty for guest recipes
+func authorOf(ctx context.Context, q db.Querier, targetType, targetID string) (string, error) {
+	query := "SELECT COALESCE(CAST(user_id AS text), '') FROM recipes WHERE id = $1"
+	if targetType == TargetComment {
+		query = "SELECT COALESCE(CAST(user_id AS text), '') FROM comments WHERE id = $1"
+	}
+
+	var authorID string
+	err := q.QueryRowContext(ctx, query, targetID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return authorID, err
+}
@@ -0,0 +1,221 @@
+package moderation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestReportContent_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1)")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO reports (reporter_id, target_type, target_id, reason, details)")).
+		WithArgs("5", "comment", "3", "spam", "Buy cheap pans").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewModerationHandler(db, "other_key")
+
+	body := `{"target_type": "comment", "target_id": "3", "reason": "spam", "details": "Buy cheap pans"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/reports", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.ReportContent(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected 201 Created, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestReportContent_InvalidReason(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewModerationHandler(db, "other_key")
+
+	body := `{"target_type": "recipe", "target_id": "1", "reason": "boring"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/reports", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.ReportContent(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %d", rr.Code)
+	}
+}
+
+func TestReportsQueue_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "target_type", "target_id", "reason", "details", "reporter", "created_at", "content", "author_id", "author_name"}).
+		AddRow("1", "comment", "3", "spam", "", "Alice", "2025-01-01T00:00:00Z", "Buy cheap pans", "2", "Bob")
+
+	mock.ExpectQuery("FROM reports rp").WithArgs("open").WillReturnRows(rows)
+
+	handler := NewModerationHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/moderation/reports", nil)
+	rr := httptest.NewRecorder()
+
+	handler.ReportsQueue(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	var got []QueueItem
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Error decoding response %v", err)
+	}
+
+	if len(got) != 1 || got[0].Content != "Buy cheap pans" || got[0].AuthorName != "Bob" {
+		t.Errorf("Unexpected content in response: %v", got)
+	}
+}
+
+func TestTakeAction_DeactivateRecipe(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT target_type, target_id, status FROM reports WHERE id = $1 FOR UPDATE")).
+		WithArgs("4").
+		WillReturnRows(sqlmock.NewRows([]string{"target_type", "target_id", "status"}).AddRow("recipe", "1", "open"))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM recipes WHERE id = $1")).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("2"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipes SET is_active = false WHERE id = $1")).
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE reports SET status = 'actioned'")).
+		WithArgs("9", "recipe", "1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO moderation_actions")).
+		WithArgs("9", "4", "deactivate_recipe", "recipe", "1", "2", "Stolen photo").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	token, _ := utils.GenerateMockJWTWithRole("9", utils.RoleModerator, "other_key")
+	handler := NewModerationHandler(db, "other_key")
+
+	body := `{"action": "deactivate_recipe", "note": "Stolen photo"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/moderation/action/4", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.TakeAction(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestTakeAction_AlreadyResolved(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT target_type, target_id, status FROM reports WHERE id = $1 FOR UPDATE")).
+		WithArgs("4").
+		WillReturnRows(sqlmock.NewRows([]string{"target_type", "target_id", "status"}).AddRow("comment", "3", "dismissed"))
+	mock.ExpectRollback()
+
+	token, _ := utils.GenerateMockJWTWithRole("9", utils.RoleModerator, "other_key")
+	handler := NewModerationHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/moderation/action/4", strings.NewReader(`{"action": "hide_content"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.TakeAction(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 Conflict, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestTakeAction_FailedAuditRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT target_type, target_id, status FROM reports WHERE id = $1 FOR UPDATE")).
+		WithArgs("4").
+		WillReturnRows(sqlmock.NewRows([]string{"target_type", "target_id", "status"}).AddRow("comment", "3", "open"))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM comments WHERE id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("2"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE comments SET is_hidden = true WHERE id = $1")).
+		WithArgs("3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE reports SET status = 'actioned'")).
+		WithArgs("9", "comment", "3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO moderation_actions")).
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	token, _ := utils.GenerateMockJWTWithRole("9", utils.RoleModerator, "other_key")
+	handler := NewModerationHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/moderation/action/4", strings.NewReader(`{"action": "hide_content"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.TakeAction(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rr.Code)
+	}
+
+	// The comment is not left hidden without an audit entry
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package moderation
+
+import (
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/events"
+)
+
+// Kinds of content that can be reported
+const (
+	TargetRecipe  = "recipe"
+	TargetComment = "comment"
+)
+
+// Moderator actions recorded in the audit trail
+const (
+	ActionDismiss          = "dismiss"
+	ActionHideContent      = "hide_content"
+	ActionDeactivateRecipe = "deactivate_recipe"
+	ActionSuspendUser      = "suspend_user"
+)
+
+var reasons = map[string]bool{
+	"spam":          true,
+	"offensive":     true,
+	"inappropriate": true,
+	"copyright":     true,
+	"other":         true,
+}
+
+// Type crafted with the moderator queue in mind
+type QueueItem struct {
+	ID           string `json:"id"`
+	TargetType   string `json:"target_type"`
+	TargetID     string `json:"target_id"`
+	Reason       string `json:"reason"`
+	Details      string `json:"details"`
+	ReporterName string `json:"reporter_name"`
+	CreatedAt    string `json:"created_at"`
+	Content      string `json:"content"`
+	AuthorID     string `json:"author_id"`
+	AuthorName   string `json:"author_name"`
+}
+
+type AuditEntry struct {
+	ID            string `json:"id"`
+	ModeratorName string `json:"moderator_name"`
+	ReportID      string `json:"report_id"`
+	Action        string `json:"action"`
+	TargetType    string `json:"target_type"`
+	TargetID      string `json:"target_id"`
+	TargetUserID  string `json:"target_user_id"`
+	Note          string `json:"note"`
+	CreatedAt     string `json:"created_at"`
+}
+
+type ModerationHandler struct {
+	DB        db.DBExecutor
+	SecretKey string
+	// Events is told when content is acted on, may be nil
+	Events *events.Dispatcher
+}
+
+func NewModerationHandler(db db.DBExecutor, secret string) *ModerationHandler {
+	return &ModerationHandler{DB: db, SecretKey: secret}
+}
@@ -0,0 +1,155 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+const (
+	DefaultPageSize = 30
+	MaxPageSize     = 100
+)
+
+// GetNotificationsAuth lists the caller's notifications, newest first. Older pages use ?before=<last id>.
+func (h *NotificationHandler) GetNotificationsAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	limit := DefaultPageSize
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = min(l, MaxPageSize)
+	}
+
+	args := []any{userID, limit}
+	before := ""
+	if b, err := strconv.Atoi(r.URL.Query().Get("before")); err == nil {
+		before = "AND n.id < $3"
+		args = append(args, b)
+	}
+
+	var unread int
+	err = h.DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userID).Scan(&unread)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT
+			n.id,
+			n.type,
+			COALESCE(n.actor_id::text, ''),
+			COALESCE(a.name, ''),
+			n.target_type,
+			n.target_id,
+			COALESCE(r.name_recipe, t.name, ''),
+			n.data,
+			n.read_at IS NOT NULL,
+			n.created_at
+		FROM notifications n
+		LEFT JOIN users a ON a.id = n.actor_id
+		LEFT JOIN recipes r ON n.target_type = 'recipe' AND r.id = n.target_id
+		LEFT JOIN users t ON n.target_type = 'user' AND t.id = n.target_id
+		WHERE n.user_id = $1 %s
+		ORDER BY n.id DESC
+		LIMIT $2`, before), args...)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+
+	for rows.Next() {
+		var n Notification
+		var data string
+		if err := rows.Scan(&n.ID, &n.Type, &n.ActorID, &n.ActorName, &n.TargetType, &n.TargetID, &n.TargetName, &data, &n.Read, &n.CreatedAt); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		n.Data = json.RawMessage(data)
+		notifications = append(notifications, n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"unread_count":  unread,
+		"notifications": notifications,
+	})
+}
+
+func (h *NotificationHandler) MarkReadAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := strconv.Atoi(id); err != nil {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(),
+		"UPDATE notifications SET read_at = COALESCE(read_at, NOW()) WHERE id = $1 AND user_id = $2",
+		id, userID,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Notification read"))
+}
+
+func (h *NotificationHandler) MarkAllReadAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), "UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL", userID); err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to update notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("All notifications read"))
+}
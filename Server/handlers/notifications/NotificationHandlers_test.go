@@ -0,0 +1,191 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/events"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestGetNotifications_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	rows := sqlmock.NewRows([]string{"id", "type", "actor_id", "actor_name", "target_type", "target_id", "target_name", "data", "read", "created_at"}).
+		AddRow("2", TypeComment, "7", "Bob", "recipe", "3", "Pozole", `{"rating":"4"}`, false, "2025-01-02T10:00:00Z").
+		AddRow("1", TypeFollow, "8", "Carol", "user", "5", "Alice", `{}`, true, "2025-01-01T10:00:00Z")
+	mock.ExpectQuery(regexp.QuoteMeta("FROM notifications n")).
+		WithArgs("5", DefaultPageSize).
+		WillReturnRows(rows)
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewNotificationHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/notifications", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.GetNotificationsAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	var got struct {
+		UnreadCount   int            `json:"unread_count"`
+		Notifications []Notification `json:"notifications"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("error decoding response %v", err)
+	}
+
+	if got.UnreadCount != 1 || len(got.Notifications) != 2 {
+		t.Fatalf("unexpected response: %+v", got)
+	}
+
+	if got.Notifications[0].TargetName != "Pozole" || string(got.Notifications[0].Data) != `{"rating":"4"}` {
+		t.Errorf("unexpected notification: %+v", got.Notifications[0])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestMarkRead_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	// Someone else's notification updates nothing
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE notifications SET read_at")).
+		WithArgs("9", "5").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewNotificationHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/notifications/9/read", nil)
+	req.SetPathValue("id", "9")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.MarkReadAuth(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 Not Found, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestMarkAllRead_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL")).
+		WithArgs("5").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewNotificationHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/notifications/read", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.MarkAllReadAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestSubscribe_CommentNotifiesRecipeOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM recipes WHERE id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("5"))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO notifications (user_id, type, actor_id, target_type, target_id, data)")).
+		WithArgs("5", TypeComment, "7", "recipe", "3", `{"rating":"4"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("11"))
+
+	dispatcher := events.NewDispatcher()
+	handler := NewNotificationHandler(db, "other_key")
+	handler.Events = dispatcher
+	handler.Subscribe(dispatcher)
+
+	var created []events.Event
+	dispatcher.Subscribe(events.NotificationCreated, func(ctx context.Context, e events.Event) error {
+		created = append(created, e)
+		return nil
+	})
+
+	dispatcher.Publish(context.Background(), events.Event{
+		Type:       events.CommentCreated,
+		ActorID:    "7",
+		TargetType: "recipe",
+		TargetID:   "3",
+		Data:       map[string]string{"rating": "4"},
+	})
+
+	if len(created) != 1 || created[0].Data["user_id"] != "5" || created[0].Data["id"] != "11" {
+		t.Errorf("unexpected notification.created events: %+v", created)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestSubscribe_OwnRecipeIsSkipped(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	// Only the owner lookup, no INSERT
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM recipes WHERE id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("7"))
+
+	dispatcher := events.NewDispatcher()
+	handler := NewNotificationHandler(db, "other_key")
+	handler.Subscribe(dispatcher)
+
+	dispatcher.Publish(context.Background(), events.Event{Type: events.RecipeRated, ActorID: "7", TargetType: "recipe", TargetID: "3"})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/Zheng5005/BiteBox/events"
+)
+
+// rule turns an event into a notification for one recipient. Supporting a new kind of
+// notification is adding its event type here.
+type rule struct {
+	Type string
+	// Recipient returns who gets the notification, empty for nobody
+	Recipient func(ctx context.Context, h *NotificationHandler, e events.Event) (string, error)
+}
+
+var rules = map[string]rule{
+	events.CommentCreated:   {Type: TypeComment, Recipient: recipeOwner},
+	events.RecipeRated:      {Type: TypeRating, Recipient: recipeOwner},
+	events.UserFollowed:     {Type: TypeFollow, Recipient: targetUser},
+	events.ContentModerated: {Type: TypeModeration, Recipient: dataField("author_id")},
+}
+
+// Subscribe makes the handler store a notification for every event that has a rule
+func (h *NotificationHandler) Subscribe(d *events.Dispatcher) {
+	for eventType, rule := range rules {
+		d.Subscribe(eventType, func(ctx context.Context, e events.Event) error {
+			return h.notify(ctx, rule, e)
+		})
+	}
+}
+
+func (h *NotificationHandler) notify(ctx context.Context, rule rule, e events.Event) error {
+	recipient, err := rule.Recipient(ctx, h, e)
+	if err != nil {
+		return err
+	}
+
+	// Nobody is told about their own actions
+	if recipient == "" || recipient == e.ActorID {
+		return nil
+	}
+
+	data, err := json.Marshal(e.Data)
+	if err != nil || e.Data == nil {
+		data = []byte("{}")
+	}
+
+	var id string
+	err = h.DB.QueryRowContext(ctx, `
+		INSERT INTO notifications (user_id, type, actor_id, target_type, target_id, data)
+		VALUES ($1, $2, NULLIF($3, '')::integer, $4, $5, $6)
+		RETURNING id`,
+		recipient, rule.Type, e.ActorID, e.TargetType, e.TargetID, string(data),
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	h.Events.Publish(ctx, events.Event{
+		Type: events.NotificationCreated,
+		Data: map[string]string{"user_id": recipient, "id": id, "type": rule.Type},
+	})
+	return nil
+}
+
+func recipeOwner(ctx context.Context, h *NotificationHandler, e events.Event) (string, error) {
+	var owner sql.NullString
+	err := h.DB.QueryRowContext(ctx, "SELECT user_id FROM recipes WHERE id = $1", e.TargetID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return owner.String, err
+}
+
+func targetUser(ctx context.Context, h *NotificationHandler, e events.Event) (string, error) {
+	return e.TargetID, nil
+}
+
+func dataField(key string) func(ctx context.Context, h *NotificationHandler, e events.Event) (string, error) {
+	return func(ctx context.Context, h *NotificationHandler, e events.Event) (string, error) {
+		return e.Data[key], nil
+	}
+}
@@ -0,0 +1,40 @@
+package notifications
+
+import (
+	"encoding/json"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/events"
+)
+
+// Notification types shown to users
+const (
+	TypeComment    = "comment"
+	TypeRating     = "rating"
+	TypeFollow     = "follow"
+	TypeModeration = "moderation"
+)
+
+type Notification struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	ActorID    string          `json:"actor_id"`
+	ActorName  string          `json:"actor_name"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	TargetName string          `json:"target_name"`
+	Data       json.RawMessage `json:"data"`
+	Read       bool            `json:"read"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+type NotificationHandler struct {
+	DB        db.DBExecutor
+	SecretKey string
+	// Events gets a notification.created event for every stored notification, may be nil
+	Events *events.Dispatcher
+}
+
+func NewNotificationHandler(db db.DBExecutor, secret string) *NotificationHandler {
+	return &NotificationHandler{DB: db, SecretKey: secret}
+}
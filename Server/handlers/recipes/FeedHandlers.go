@@ -0,0 +1,138 @@
+package recipes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+const (
+	DefaultFeedPageSize = 20
+	MaxFeedPageSize     = 50
+)
+
+// FollowingFeedAuth lists the active recipes of the users the caller follows, newest first.
+// Pages are chained with the next_cursor of the previous response passed as ?cursor.
+func (h *RecipesHandler) FollowingFeedAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	limit := DefaultFeedPageSize
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = min(l, MaxFeedPageSize)
+	}
+
+	// One extra row tells whether there is a next page
+	args := []any{userID, limit + 1}
+	after := ""
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeFeedCursor(cursor)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = "AND (r.created_at, r.id) < ($3, $4)"
+		args = append(args, createdAt, id)
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), fmt.Sprintf(`
+		SELECT
+			r.id,
+			r.name_recipe,
+			r.description,
+			r.meal_type_id,
+			COALESCE(r.img_url, ''),
+			COALESCE(r.img_card_url, r.img_url, ''),
+			u.id,
+			u.name,
+			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0),
+			r.created_at
+		FROM follows f
+		JOIN recipes r ON r.user_id = f.followee_id
+		JOIN users u ON u.id = r.user_id
+		LEFT JOIN comments c ON c.recipe_id = r.id AND c.is_hidden = false
+		WHERE f.follower_id = $1 AND r.is_active = true %s
+		GROUP BY r.id, u.id
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT $2`, after), args...)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	recipes := []FeedRecipe{}
+	var lastCreatedAt time.Time
+
+	for rows.Next() {
+		var rec FeedRecipe
+		var createdAt time.Time
+		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Description, &rec.MealTypeID, &rec.ImgURL, &rec.ImgCardURL, &rec.AuthorID, &rec.AuthorName, &rec.Rating, &createdAt); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		rec.CreatedAt = createdAt.Format(time.RFC3339)
+		recipes = append(recipes, rec)
+		if len(recipes) <= limit {
+			lastCreatedAt = createdAt
+		}
+	}
+
+	nextCursor := ""
+	if len(recipes) > limit {
+		recipes = recipes[:limit]
+		nextCursor = encodeFeedCursor(lastCreatedAt, recipes[limit-1].ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"recipes":     recipes,
+		"next_cursor": nextCursor,
+	})
+}
+
+// The cursor is the position of the last recipe of the page, opaque to clients
+func encodeFeedCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeFeedCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	return createdAt, n, nil
+}
@@ -0,0 +1,97 @@
+package recipes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestFollowingFeed_Pages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	newest := time.Date(2025, 3, 2, 12, 0, 0, 0, time.UTC)
+	older := newest.Add(-time.Hour)
+
+	columns := []string{"id", "name_recipe", "description", "meal_type_id", "img_url", "img_card_url", "author_id", "author_name", "rating", "created_at"}
+	mock.ExpectQuery(regexp.QuoteMeta("FROM follows f")).
+		WithArgs("5", 2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("9", "Soup", "Warm", "1", "", "", "7", "Jane", "4.00", newest).
+			AddRow("8", "Salad", "Fresh", "2", "", "", "7", "Jane", "0", older))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewRecipesHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/feed/following?limit=1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.FollowingFeedAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	var page struct {
+		Recipes    []FeedRecipe `json:"recipes"`
+		NextCursor string       `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+
+	if len(page.Recipes) != 1 || page.Recipes[0].ID != "9" || page.NextCursor == "" {
+		t.Fatalf("Expected the newest recipe and a cursor, got %+v", page)
+	}
+
+	// The cursor continues right after the last recipe of the page
+	mock.ExpectQuery(regexp.QuoteMeta("AND (r.created_at, r.id) < ($3, $4)")).
+		WithArgs("5", 2, newest, 9).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("8", "Salad", "Fresh", "2", "", "", "7", "Jane", "0", older))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/feed/following?limit=1&cursor="+page.NextCursor, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+
+	handler.FollowingFeedAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestFollowingFeed_InvalidCursor(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewRecipesHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/feed/following?cursor=nope", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.FollowingFeedAuth(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %d", rr.Code)
+	}
+}
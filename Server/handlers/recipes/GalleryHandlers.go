@@ -0,0 +1,446 @@
+package recipes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Zheng5005/BiteBox/assets"
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/imaging"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+	"github.com/lib/pq"
+)
+
+const (
+	MaxImagesPerRecipe = 12
+	MaxCaptionLength   = 200
+)
+
+var (
+	errGalleryFull     = errors.New("recipe already has the maximum number of photos")
+	errIncompleteOrder = errors.New("image ids do not list every photo of the recipe once")
+)
+
+// syncCover copies the cover photo to the recipe row, which the list endpoints read
+const syncCover = `
+	UPDATE recipes SET img_url = c.url, img_detail_url = c.url, img_card_url = c.card_url
+	FROM (
+		SELECT
+			(SELECT url FROM recipe_images WHERE recipe_id = $1 AND is_cover) AS url,
+			(SELECT card_url FROM recipe_images WHERE recipe_id = $1 AND is_cover) AS card_url
+	) c
+	WHERE recipes.id = $1`
+
+func (h *RecipesHandler) gallery(ctx context.Context, recipeID string) ([]RecipeImage, error) {
+	rows, err := h.DB.QueryContext(ctx,
+		"SELECT id, url, card_url, caption, position, is_cover FROM recipe_images WHERE recipe_id = $1 ORDER BY position, id",
+		recipeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []RecipeImage{}
+	for rows.Next() {
+		var img RecipeImage
+		if err := rows.Scan(&img.ID, &img.URL, &img.CardURL, &img.Caption, &img.Position, &img.IsCover); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	return images, rows.Err()
+}
+
+// ownRecipe returns the recipe in the path and the caller, who must own it. Otherwise it writes
+// the error response and returns false.
+func (h *RecipesHandler) ownRecipe(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	recipeID := r.PathValue("id")
+	if _, err := strconv.Atoi(recipeID); err != nil {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return "", "", false
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	var owner sql.NullString
+	err = h.DB.QueryRowContext(r.Context(), "SELECT user_id FROM recipes WHERE id = $1", recipeID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return "", "", false
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error loading recipe", http.StatusInternalServerError)
+		return "", "", false
+	}
+
+	if owner.String != userID {
+		http.Error(w, "Only the author can change the photos", http.StatusForbidden)
+		return "", "", false
+	}
+
+	return recipeID, userID, true
+}
+
+func (h *RecipesHandler) AddImageAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	recipeID, userID, ok := h.ownRecipe(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	caption := strings.TrimSpace(r.FormValue("caption"))
+	if utf8.RuneCountInString(caption) > MaxCaptionLength {
+		http.Error(w, "Caption is too long", http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	if err := h.DB.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1", recipeID).Scan(&count); err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error adding photo", http.StatusInternalServerError)
+		return
+	}
+
+	if count >= MaxImagesPerRecipe {
+		http.Error(w, "Recipe already has the maximum number of photos", http.StatusConflict)
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "Missing image", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	urls, err := imaging.Save(assets.WithOwner(r.Context(), userID), h.Images, file, imaging.Card, imaging.Detail)
+	if imaging.Rejected(err) {
+		http.Error(w, "Invalid image: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("Image upload error", "err", err)
+		http.Error(w, "Error uploading image", http.StatusInternalServerError)
+		return
+	}
+
+	img := RecipeImage{URL: urls[imaging.Detail.Name], CardURL: urls[imaging.Card.Name], Caption: caption}
+	err = db.InTx(r.Context(), h.DB, func(tx db.Querier) error {
+		if err := lockRecipe(r.Context(), tx, recipeID); err != nil {
+			return err
+		}
+
+		// Counted again, another upload may have filled the gallery meanwhile
+		var count int
+		if err := tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1", recipeID).Scan(&count); err != nil {
+			return err
+		}
+		if count >= MaxImagesPerRecipe {
+			return errGalleryFull
+		}
+
+		// New photos go last, the first one becomes the cover
+		err := tx.QueryRowContext(r.Context(), `
+			INSERT INTO recipe_images (recipe_id, url, card_url, caption, position, is_cover)
+			SELECT $1, $2, $3, $4, COALESCE(MAX(position) + 1, 0), COUNT(*) FILTER (WHERE is_cover) = 0
+			FROM recipe_images WHERE recipe_id = $1
+			RETURNING id, position, is_cover`,
+			recipeID, img.URL, img.CardURL, img.Caption,
+		).Scan(&img.ID, &img.Position, &img.IsCover)
+		if err != nil {
+			return err
+		}
+
+		if r.FormValue("cover") == "true" && !img.IsCover {
+			if err := setCover(r.Context(), tx, recipeID, img.ID); err != nil {
+				return err
+			}
+			img.IsCover = true
+		}
+
+		if img.IsCover {
+			_, err = tx.ExecContext(r.Context(), syncCover, recipeID)
+		}
+		return err
+	})
+	if errors.Is(err, errGalleryFull) {
+		http.Error(w, "Recipe already has the maximum number of photos", http.StatusConflict)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error adding photo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(img)
+}
+
+// UpdateImageAuth changes the caption of a photo or makes it the cover
+func (h *RecipesHandler) UpdateImageAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	recipeID, _, ok := h.ownRecipe(w, r)
+	if !ok {
+		return
+	}
+
+	imageID := r.PathValue("imageId")
+	if _, err := strconv.Atoi(imageID); err != nil {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	}
+
+	var input struct {
+		Caption *string `json:"caption"`
+		Cover   bool    `json:"cover"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if input.Caption != nil && utf8.RuneCountInString(strings.TrimSpace(*input.Caption)) > MaxCaptionLength {
+		http.Error(w, "Caption is too long", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err := h.DB.QueryRowContext(r.Context(),
+		"SELECT EXISTS (SELECT 1 FROM recipe_images WHERE id = $1 AND recipe_id = $2)", imageID, recipeID,
+	).Scan(&exists)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error updating photo", http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	}
+
+	err = db.InTx(r.Context(), h.DB, func(tx db.Querier) error {
+		if err := lockRecipe(r.Context(), tx, recipeID); err != nil {
+			return err
+		}
+
+		if input.Caption != nil {
+			_, err := tx.ExecContext(r.Context(), "UPDATE recipe_images SET caption = $1 WHERE id = $2", strings.TrimSpace(*input.Caption), imageID)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !input.Cover {
+			return nil
+		}
+		if err := setCover(r.Context(), tx, recipeID, imageID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(r.Context(), syncCover, recipeID)
+		return err
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error updating photo", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Photo updated"))
+}
+
+// ReorderImagesAuth takes every photo id of the recipe in the new order
+func (h *RecipesHandler) ReorderImagesAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	recipeID, _, ok := h.ownRecipe(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ImageIDs []string `json:"image_ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	ids := make([]int64, 0, len(input.ImageIDs))
+	seen := map[int64]bool{}
+	for _, s := range input.ImageIDs {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || seen[id] {
+			http.Error(w, "image_ids must list every photo of the recipe once", http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	err := db.InTx(r.Context(), h.DB, func(tx db.Querier) error {
+		if err := lockRecipe(r.Context(), tx, recipeID); err != nil {
+			return err
+		}
+
+		var count int
+		if err := tx.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1", recipeID).Scan(&count); err != nil {
+			return err
+		}
+
+		if count != len(ids) {
+			return errIncompleteOrder
+		}
+
+		res, err := tx.ExecContext(r.Context(), `
+			UPDATE recipe_images SET position = array_position($2::integer[], id) - 1
+			WHERE recipe_id = $1 AND id = ANY($2::integer[])`,
+			recipeID, pq.Array(ids),
+		)
+		if err != nil {
+			return err
+		}
+
+		// An id from another recipe leaves one of this recipe's photos out
+		if updated, _ := res.RowsAffected(); int(updated) != count {
+			return errIncompleteOrder
+		}
+		return nil
+	})
+	if errors.Is(err, errIncompleteOrder) {
+		http.Error(w, "image_ids must list every photo of the recipe once", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error reordering photos", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Photos reordered"))
+}
+
+func (h *RecipesHandler) DeleteImageAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	recipeID, _, ok := h.ownRecipe(w, r)
+	if !ok {
+		return
+	}
+
+	imageID := r.PathValue("imageId")
+	if _, err := strconv.Atoi(imageID); err != nil {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	}
+
+	err := db.InTx(r.Context(), h.DB, func(tx db.Querier) error {
+		if err := lockRecipe(r.Context(), tx, recipeID); err != nil {
+			return err
+		}
+
+		var wasCover bool
+		err := tx.QueryRowContext(r.Context(),
+			"DELETE FROM recipe_images WHERE id = $1 AND recipe_id = $2 RETURNING is_cover", imageID, recipeID,
+		).Scan(&wasCover)
+		if err != nil || !wasCover {
+			return err
+		}
+
+		// The first remaining photo takes over as cover
+		_, err = tx.ExecContext(r.Context(), `
+			UPDATE recipe_images SET is_cover = true
+			WHERE id = (SELECT id FROM recipe_images WHERE recipe_id = $1 ORDER BY position, id LIMIT 1)`,
+			recipeID,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(r.Context(), syncCover, recipeID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "Photo not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error deleting photo", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Photo deleted"))
+}
+
+// lockRecipe holds the recipe row until the transaction ends, so changes to one gallery
+// run one after the other
+func lockRecipe(ctx context.Context, q db.Querier, recipeID string) error {
+	_, err := q.ExecContext(ctx, "SELECT 1 FROM recipes WHERE id = $1 FOR UPDATE", recipeID)
+	return err
+}
+
+// setCover clears the old cover first, only one cover per recipe is allowed at any time
+func setCover(ctx context.Context, q db.Querier, recipeID, imageID string) error {
+	_, err := q.ExecContext(ctx, "UPDATE recipe_images SET is_cover = false WHERE recipe_id = $1 AND is_cover AND id <> $2", recipeID, imageID)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, "UPDATE recipe_images SET is_cover = true WHERE id = $1 AND recipe_id = $2", imageID, recipeID)
+	return err
+}
+
+// ReplaceCover makes a new image the recipe's cover photo. A current cover is replaced in place,
+// otherwise the image goes in front of the gallery. Run it with the recipe row locked.
+func ReplaceCover(ctx context.Context, q db.Querier, recipeID, url, cardURL string) error {
+	res, err := q.ExecContext(ctx, "UPDATE recipe_images SET url = $2, card_url = $3 WHERE recipe_id = $1 AND is_cover", recipeID, url, cardURL)
+	if err != nil {
+		return err
+	}
+	if replaced, _ := res.RowsAffected(); replaced > 0 {
+		return nil
+	}
+
+	_, err = q.ExecContext(ctx, "UPDATE recipe_images SET position = position + 1 WHERE recipe_id = $1", recipeID)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, "INSERT INTO recipe_images (recipe_id, url, card_url, position, is_cover) VALUES ($1, $2, $3, 0, true)", recipeID, url, cardURL)
+	return err
+}
@@ -0,0 +1,312 @@
+package recipes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func expectOwner(mock sqlmock.Sqlmock, recipeID, ownerID string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM recipes WHERE id = $1")).
+		WithArgs(recipeID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(ownerID))
+}
+
+func expectLock(mock sqlmock.Sqlmock, recipeID string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT 1 FROM recipes WHERE id = $1 FOR UPDATE")).
+		WithArgs(recipeID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestAddImage_FirstPhotoBecomesCover(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	store, err := lib.NewLocalStore(t.TempDir(), "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create image store: %v", err)
+	}
+
+	expectOwner(mock, "3", "5")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	expectLock(mock, "3")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO recipe_images (recipe_id, url, card_url, caption, position, is_cover)")).
+		WithArgs("3", sqlmock.AnyArg(), sqlmock.AnyArg(), "Plated").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "position", "is_cover"}).AddRow("8", 0, true))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipes SET img_url = c.url")).
+		WithArgs("3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handler := NewRecipesHandler(db, "other_key")
+	handler.Images = store
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("caption", " Plated ")
+	part, _ := writer.CreateFormFile("image", "plated.png")
+	part.Write(img.Bytes())
+	writer.Close()
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	req := httptest.NewRequest(http.MethodPost, "/api/recipes/3/images", &body)
+	req.SetPathValue("id", "3")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.AddImageAuth(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 Created, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var got RecipeImage
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+
+	if got.ID != "8" || !got.IsCover || !strings.HasPrefix(got.CardURL, "http://localhost:8080/media/") {
+		t.Errorf("Unexpected photo: %+v", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAddImage_NotOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	expectOwner(mock, "3", "7")
+
+	handler := NewRecipesHandler(db, "other_key")
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	req := httptest.NewRequest(http.MethodPost, "/api/recipes/3/images", nil)
+	req.SetPathValue("id", "3")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.AddImageAuth(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 Forbidden, got %d", rr.Code)
+	}
+}
+
+func TestAddImage_GalleryFilledMeanwhile(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	store, err := lib.NewLocalStore(t.TempDir(), "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create image store: %v", err)
+	}
+
+	expectOwner(mock, "3", "5")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(MaxImagesPerRecipe - 1))
+	expectLock(mock, "3")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(MaxImagesPerRecipe))
+	mock.ExpectRollback()
+
+	handler := NewRecipesHandler(db, "other_key")
+	handler.Images = store
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("image", "plated.png")
+	part.Write(img.Bytes())
+	writer.Close()
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	req := httptest.NewRequest(http.MethodPost, "/api/recipes/3/images", &body)
+	req.SetPathValue("id", "3")
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.AddImageAuth(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 Conflict, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestReorderImages_MustListEveryPhoto(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	expectOwner(mock, "3", "5")
+	expectLock(mock, "3")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectRollback()
+
+	handler := NewRecipesHandler(db, "other_key")
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	req := httptest.NewRequest(http.MethodPut, "/api/recipes/3/images", strings.NewReader(`{"image_ids": ["9", "8"]}`))
+	req.SetPathValue("id", "3")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.ReorderImagesAuth(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestReorderImages_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	expectOwner(mock, "3", "5")
+	expectLock(mock, "3")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM recipe_images WHERE recipe_id = $1")).
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipe_images SET position = array_position($2::integer[], id) - 1")).
+		WithArgs("3", "{9,8}").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	handler := NewRecipesHandler(db, "other_key")
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	req := httptest.NewRequest(http.MethodPut, "/api/recipes/3/images", strings.NewReader(`{"image_ids": ["9", "8"]}`))
+	req.SetPathValue("id", "3")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.ReorderImagesAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestDeleteImage_PromotesNextCover(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	expectOwner(mock, "3", "5")
+	expectLock(mock, "3")
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM recipe_images WHERE id = $1 AND recipe_id = $2 RETURNING is_cover")).
+		WithArgs("8", "3").
+		WillReturnRows(sqlmock.NewRows([]string{"is_cover"}).AddRow(true))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipe_images SET is_cover = true")).
+		WithArgs("3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipes SET img_url = c.url")).
+		WithArgs("3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handler := NewRecipesHandler(db, "other_key")
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	req := httptest.NewRequest(http.MethodDelete, "/api/recipes/3/images/8", nil)
+	req.SetPathValue("id", "3")
+	req.SetPathValue("imageId", "8")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.DeleteImageAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestReplaceCover_NewCoverGoesFirst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	// No cover to replace, the photos already there move back one place
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipe_images SET url = $2, card_url = $3 WHERE recipe_id = $1 AND is_cover")).
+		WithArgs("3", "detail.jpg", "card.jpg").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipe_images SET position = position + 1 WHERE recipe_id = $1")).
+		WithArgs("3").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO recipe_images (recipe_id, url, card_url, position, is_cover) VALUES ($1, $2, $3, 0, true)")).
+		WithArgs("3", "detail.jpg", "card.jpg").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := ReplaceCover(context.Background(), db, "3", "detail.jpg", "card.jpg"); err != nil {
+		t.Fatalf("ReplaceCover failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -3,11 +3,14 @@ package recipes
 import (
 	"database/sql"
 	"encoding/json"
-	"log"
 	"net/http"
 	"strings"
 
-	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/assets"
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/imaging"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/metrics"
 	"github.com/Zheng5005/BiteBox/utils"
 )
 
@@ -17,16 +20,17 @@ func (h *RecipesHandler) RecipeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := h.DB.Query(`
+	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT 
 			r.id, 
 			r.name_recipe, 
 			r.description, 
 			r.meal_type_id, 
 			COALESCE(r.img_url, ''),
+			COALESCE(r.img_card_url, r.img_url, ''),
 			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg 
 		FROM recipes r 
-		LEFT JOIN comments c ON r.id = c.recipe_id 
+		LEFT JOIN comments c ON r.id = c.recipe_id AND c.is_hidden = false 
 		WHERE r.is_active = true
 		GROUP BY r.id`)
 	if err != nil {
@@ -38,13 +42,13 @@ func (h *RecipesHandler) RecipeHandler(w http.ResponseWriter, r *http.Request) {
 	var recipes []RecipesMainPage
 
 	for rows.Next() {
-		var r RecipesMainPage
-		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.MealTypeID, &r.ImgURL, &r.Rating); err != nil {
-			log.Println(err)
+		var recipe RecipesMainPage
+		if err := rows.Scan(&recipe.ID, &recipe.Name, &recipe.Description, &recipe.MealTypeID, &recipe.ImgURL, &recipe.ImgCardURL, &recipe.Rating); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
-		recipes = append(recipes, r)
+		recipes = append(recipes, recipe)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -70,24 +74,26 @@ func (h *RecipesHandler) RecipeONEHandler(w http.ResponseWriter, r *http.Request
 				r.description,
 				r.meal_type_id,
 				COALESCE(r.img_url, ''),
+				COALESCE(r.img_detail_url, r.img_url, ''),
 				COALESCE(u.name, r.guest_name) AS creator_name,
 				COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg_rating,
 				r.steps
 			FROM recipes r
 			LEFT JOIN users u ON u.id = r.user_id
-			LEFT JOIN comments c ON c.recipe_id = r.id
+			LEFT JOIN comments c ON c.recipe_id = r.id AND c.is_hidden = false
 			WHERE r.id = $1 AND r.is_active = true
 			GROUP BY r.id, u.name, r.guest_name;
 		`
 
 		var recipe RecipeDetail
 			
-		err := h.DB.QueryRow(query, id).Scan(
+		err := h.DB.QueryRowContext(r.Context(), query, id).Scan(
 			&recipe.ID,
 			&recipe.Name,
 			&recipe.Description,
 			&recipe.MealTypeID,
 			&recipe.ImgURL,
+			&recipe.ImgDetailURL,
 			&recipe.CreatorName,
 			&recipe.Rating,
 			&recipe.Steps,
@@ -97,7 +103,14 @@ func (h *RecipesHandler) RecipeONEHandler(w http.ResponseWriter, r *http.Request
 			http.Error(w, "Recipe not found", http.StatusNotFound)
 			return
 		} else if err != nil {
-			log.Println("Scan error:", err)
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Error retrieving recipe", http.StatusInternalServerError)
+			return
+		}
+
+		recipe.Images, err = h.gallery(r.Context(), id)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("DB error", "err", err)
 			http.Error(w, "Error retrieving recipe", http.StatusInternalServerError)
 			return
 		}
@@ -127,47 +140,64 @@ func (h *RecipesHandler) PostRecipe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("image")
-	var imageURL string
+	// Guests post without a token, a token that is sent had its session checked by the route's middleware
+	userID, tokenErr := utils.ParseToken(r, h.SecretKey)
+	guest_name := r.FormValue("guest_name")
+	if tokenErr != nil && guest_name == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	var imageURL, cardURL string
 
 	if err == nil {
 		defer file.Close()
 
-		imageURL, err = lib.UploadToCloudinary(file, fileHeader.Filename)
-		if err != nil {
+		urls, err := imaging.Save(assets.WithOwner(r.Context(), userID), h.Images, file, imaging.Card, imaging.Detail)
+		if imaging.Rejected(err) {
+			http.Error(w, "Invalid image: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logging.FromContext(r.Context()).Error("Image upload error", "err", err)
 			http.Error(w, "Error uploading image", http.StatusInternalServerError)
 			return
 		}
+		imageURL, cardURL = urls[imaging.Detail.Name], urls[imaging.Card.Name]
 	} else if err != http.ErrMissingFile {
 		http.Error(w, "Error reading file", http.StatusBadRequest)
 		return
 	}
 
-	userID, tokenErr := utils.ParseToken(r, h.SecretKey)
-
-	if tokenErr == nil {
-		_, err = h.DB.Exec(
-			"INSERT INTO recipes (user_id, name_recipe, description, meal_type_id, img_url, steps) VALUES ($1, $2, $3, $4, $5, $6)",
-			userID, name_recipe, description, meal_type_id, imageURL, steps,
-		)
-	} else {
-		guest_name := r.FormValue("guest_name")
-		if guest_name == "" {
-			http.Error(w, "Missing required fields", http.StatusBadRequest)
-			return
+	// The recipe and its cover are stored together, or a failed cover would leave a recipe
+	// without the image it was posted with
+	err = db.InTx(r.Context(), h.DB, func(tx db.Querier) error {
+		var recipeID string
+		var err error
+		if tokenErr == nil {
+			err = tx.QueryRowContext(r.Context(),
+				"INSERT INTO recipes (user_id, name_recipe, description, meal_type_id, img_url, steps, img_card_url, img_detail_url) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($5, '')) RETURNING id",
+				userID, name_recipe, description, meal_type_id, imageURL, steps, cardURL,
+			).Scan(&recipeID)
+		} else {
+			err = tx.QueryRowContext(r.Context(),
+				"INSERT INTO recipes (guest_name, name_recipe, description, meal_type_id, img_url, steps, img_card_url, img_detail_url) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($5, '')) RETURNING id",
+				guest_name, name_recipe, description, meal_type_id, imageURL, steps, cardURL,
+			).Scan(&recipeID)
+		}
+		if err != nil || imageURL == "" {
+			return err
 		}
 
-		_, err = h.DB.Exec(
-			"INSERT INTO recipes (guest_name, name_recipe, description, meal_type_id, img_url, steps) VALUES ($1, $2, $3, $4, $5, $6)",
-			guest_name, name_recipe, description, meal_type_id, imageURL, steps,
-		)
-	}
-
+		// The uploaded image starts the gallery as its cover, nobody else knows the recipe yet
+		return ReplaceCover(r.Context(), tx, recipeID, imageURL, cardURL)
+	})
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Error("Error creating recipe", "err", err)
 		http.Error(w, "Error creating recipe", http.StatusInternalServerError)
 		return
 	}
+	metrics.RecipesCreated.Inc()
 
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Recipe Created"))
@@ -3,14 +3,19 @@ package recipes
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/lib"
 	"github.com/Zheng5005/BiteBox/utils"
 )
 
@@ -23,9 +28,9 @@ func TestGetRecipes_Success(t *testing.T)  {
 	defer db.Close()
 
 	// expected rows
-	rows := sqlmock.NewRows([]string{"id", "name_recipe", "description", "meal_type_id", "img_url", "rating"}).
-		AddRow("1", "Carbonara", "Best pasta in Italy", "2", "", "5").
-		AddRow("2", "Pupusas", "La mejor comida de El Salvador", "1", "", "5")
+	rows := sqlmock.NewRows([]string{"id", "name_recipe", "description", "meal_type_id", "img_url", "img_card_url", "rating"}).
+		AddRow("1", "Carbonara", "Best pasta in Italy", "2", "", "", "5").
+		AddRow("2", "Pupusas", "La mejor comida de El Salvador", "1", "", "", "5")
 
 	mock.ExpectQuery(regexp.QuoteMeta(`
 		SELECT 
@@ -34,9 +39,10 @@ func TestGetRecipes_Success(t *testing.T)  {
 			r.description, 
 			r.meal_type_id, 
 			COALESCE(r.img_url, ''),
+			COALESCE(r.img_card_url, r.img_url, ''),
 			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg 
 		FROM recipes r 
-		LEFT JOIN comments c ON r.id = c.recipe_id 
+		LEFT JOIN comments c ON r.id = c.recipe_id AND c.is_hidden = false 
 		WHERE r.is_active = true
 		GROUP BY r.id
 	`)).WillReturnRows(rows)
@@ -75,8 +81,8 @@ func TestGetRecipe_Success(t *testing.T)  {
 	defer db.Close()
 
 	// expected rows
-	rows := sqlmock.NewRows([]string{"id", "name_recipe", "description", "meal_type_id", "img_url", "creator_name", "avg_rating", "steps"}).
-		AddRow("1", "Carbonara", "Best pasta in Italy", "2", "", "Tizio Acaso",  "5", "Put pancetta in pasta")
+	rows := sqlmock.NewRows([]string{"id", "name_recipe", "description", "meal_type_id", "img_url", "img_detail_url", "creator_name", "avg_rating", "steps"}).
+		AddRow("1", "Carbonara", "Best pasta in Italy", "2", "", "", "Tizio Acaso",  "5", "Put pancetta in pasta")
 
 	mock.ExpectQuery(regexp.QuoteMeta(`
 		SELECT 
@@ -85,16 +91,23 @@ func TestGetRecipe_Success(t *testing.T)  {
 			r.description,
 			r.meal_type_id,
 			COALESCE(r.img_url, ''),
+			COALESCE(r.img_detail_url, r.img_url, ''),
 			COALESCE(u.name, r.guest_name) AS creator_name,
 			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg_rating,
 			r.steps
 		FROM recipes r
 		LEFT JOIN users u ON u.id = r.user_id
-		LEFT JOIN comments c ON c.recipe_id = r.id
+		LEFT JOIN comments c ON c.recipe_id = r.id AND c.is_hidden = false
 		WHERE r.id = $1 AND r.is_active = true
 		GROUP BY r.id, u.name, r.guest_name;
 	`)).WithArgs("1").WillReturnRows(rows)
 
+	mock.ExpectQuery(regexp.QuoteMeta("FROM recipe_images WHERE recipe_id = $1 ORDER BY position, id")).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "card_url", "caption", "position", "is_cover"}).
+			AddRow("4", "https://img/detail.jpg", "https://img/card.jpg", "Plated", 0, true).
+			AddRow("5", "https://img/detail2.jpg", "https://img/card2.jpg", "", 1, false))
+
 	handler := NewRecipesHandler(db, "other_key")
 
 	req := httptest.NewRequest(http.MethodGet, "/api/recipes/1", nil)
@@ -114,6 +127,10 @@ func TestGetRecipe_Success(t *testing.T)  {
 	if got.Name != "Carbonara" || got.CreatorName != "Tizio Acaso" {
 		t.Errorf("Unexpected content in response: %v", got)
 	}
+
+	if len(got.Images) != 2 || !got.Images[0].IsCover || got.Images[0].Caption != "Plated" {
+		t.Errorf("Unexpected gallery in response: %+v", got.Images)
+	}
 }
 
 func TestGetRecipe_InactiveReturnsNotFound(t *testing.T) {
@@ -123,7 +140,7 @@ func TestGetRecipe_InactiveReturnsNotFound(t *testing.T) {
 	}
 	defer db.Close()
 
-	rows := sqlmock.NewRows([]string{"id", "name_recipe", "description", "meal_type_id", "img_url", "creator_name", "avg_rating", "steps"})
+	rows := sqlmock.NewRows([]string{"id", "name_recipe", "description", "meal_type_id", "img_url", "img_detail_url", "creator_name", "avg_rating", "steps"})
 
 	mock.ExpectQuery(regexp.QuoteMeta(`
 		SELECT 
@@ -132,12 +149,13 @@ func TestGetRecipe_InactiveReturnsNotFound(t *testing.T) {
 			r.description,
 			r.meal_type_id,
 			COALESCE(r.img_url, ''),
+			COALESCE(r.img_detail_url, r.img_url, ''),
 			COALESCE(u.name, r.guest_name) AS creator_name,
 			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg_rating,
 			r.steps
 		FROM recipes r
 		LEFT JOIN users u ON u.id = r.user_id
-		LEFT JOIN comments c ON c.recipe_id = r.id
+		LEFT JOIN comments c ON c.recipe_id = r.id AND c.is_hidden = false
 		WHERE r.id = $1 AND r.is_active = true
 		GROUP BY r.id, u.name, r.guest_name;
 	`)).WithArgs("1").WillReturnRows(rows)
@@ -192,9 +210,11 @@ func TestPostRecipeGuest_Sucess(t *testing.T)  {
 	_ = writer.WriteField("guest_name", "Guesty")
 	writer.Close()
 
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO recipes (guest_name, name_recipe, description, meal_type_id, img_url, steps)")).
-		WithArgs("Guesty", "Pupusas", "Best food", "1", "", "Mix and cook").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO recipes (guest_name, name_recipe, description, meal_type_id, img_url, steps, img_card_url, img_detail_url)")).
+		WithArgs("Guesty", "Pupusas", "Best food", "1", "", "Mix and cook", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectCommit()
 
 	req := httptest.NewRequest(http.MethodPost, "/api/recipes/guest", &body)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
@@ -239,9 +259,11 @@ func TestPostRecipeUser_Sucess(t *testing.T)  {
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("Authorization", "Bearer "+token) // Simulated token
 
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO recipes (user_id, name_recipe, description, meal_type_id, img_url, steps)")).
-		WithArgs("user-id-123", "Pizza", "Yummy", "2", "", "Bake it").
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO recipes (user_id, name_recipe, description, meal_type_id, img_url, steps, img_card_url, img_detail_url)")).
+		WithArgs("user-id-123", "Pizza", "Yummy", "2", "", "Bake it", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectCommit()
 
 	rr := httptest.NewRecorder()
 	handler.PostRecipe(rr, req)
@@ -254,3 +276,160 @@ func TestPostRecipeUser_Sucess(t *testing.T)  {
 		t.Errorf("Expected body 'Recipe Created', got '%s'", rr.Body.String())
 	}
 }
+
+func TestPostRecipeWithImage_Success(t *testing.T)  {
+	// Setup DB mock
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	// Images go to a temporary directory instead of Cloudinary
+	dir := t.TempDir()
+	store, err := lib.NewLocalStore(dir, "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create image store: %v", err)
+	}
+
+	handler := NewRecipesHandler(db, "other_key")
+	handler.Images = store
+
+	// Prepare multipart form with a real PNG
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("name", "Pupusas")
+	_ = writer.WriteField("description", "Best food")
+	_ = writer.WriteField("steps", "Mix and cook")
+	_ = writer.WriteField("meal_type_id", "1")
+	_ = writer.WriteField("guest_name", "Guesty")
+	part, _ := writer.CreateFormFile("image", "pupusas.png")
+	part.Write(img.Bytes())
+	writer.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO recipes (guest_name, name_recipe, description, meal_type_id, img_url, steps, img_card_url, img_detail_url)")).
+		WithArgs("Guesty", "Pupusas", "Best food", "1", sqlmock.AnyArg(), "Mix and cook", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipe_images SET url = $2, card_url = $3 WHERE recipe_id = $1 AND is_cover")).
+		WithArgs("1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipe_images SET position = position + 1 WHERE recipe_id = $1")).
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO recipe_images (recipe_id, url, card_url, position, is_cover)")).
+		WithArgs("1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/recipes/guest", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+
+	handler.PostRecipe(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected status 201 Created, got %d", rr.Code)
+	}
+
+	// The card and detail variants
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Errorf("Expected 2 stored images, got %d", len(files))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPostRecipeWithImage_CoverFailsRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	store, err := lib.NewLocalStore(t.TempDir(), "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create image store: %v", err)
+	}
+
+	handler := NewRecipesHandler(db, "other_key")
+	handler.Images = store
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("name", "Pupusas")
+	_ = writer.WriteField("description", "Best food")
+	_ = writer.WriteField("steps", "Mix and cook")
+	_ = writer.WriteField("meal_type_id", "1")
+	_ = writer.WriteField("guest_name", "Guesty")
+	part, _ := writer.CreateFormFile("image", "pupusas.png")
+	part.Write(img.Bytes())
+	writer.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO recipes (guest_name, name_recipe, description, meal_type_id, img_url, steps, img_card_url, img_detail_url)")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipe_images SET url = $2, card_url = $3 WHERE recipe_id = $1 AND is_cover")).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/recipes/guest", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+
+	handler.PostRecipe(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 Internal Server Error, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPostRecipe_RejectsNonImage(t *testing.T)  {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock DB: %v", err)
+	}
+	defer db.Close()
+
+	store, err := lib.NewLocalStore(t.TempDir(), "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create image store: %v", err)
+	}
+
+	handler := NewRecipesHandler(db, "other_key")
+	handler.Images = store
+
+	// A script named like a picture
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("name", "Pupusas")
+	_ = writer.WriteField("description", "Best food")
+	_ = writer.WriteField("steps", "Mix and cook")
+	_ = writer.WriteField("meal_type_id", "1")
+	_ = writer.WriteField("guest_name", "Guesty")
+	part, _ := writer.CreateFormFile("image", "pupusas.jpg")
+	part.Write([]byte("<script>alert(1)</script>"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/recipes/guest", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+
+	handler.PostRecipe(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 Bad Request, got %d", rr.Code)
+	}
+}
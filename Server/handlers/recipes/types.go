@@ -1,6 +1,9 @@
 package recipes
 
-import "github.com/Zheng5005/BiteBox/db"
+import (
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/lib"
+)
 
 type RecipePost struct {
 	ID   string `json:"id"`
@@ -20,27 +23,56 @@ type RecipesMainPage struct {
 	Description string `json:"description"`
 	MealTypeID string `json:"meal_type_id"`
 	ImgURL string `json:"img_url"`
+	ImgCardURL string `json:"img_card_url"`
 
 	Rating string `json:"rating"`
 }
 
 //Type crafted with recipe detail page in mind
 type RecipeDetail struct {
-	ID          string `json:"id"`
-	Name        string `json:"name_recipe"`
-	Description string `json:"description"`
-	MealTypeID  string `json:"meal_type_id"`
-	ImgURL      string `json:"img_url"`
-	CreatorName string `json:"creator_name"`
-	Rating      string `json:"rating"`
-	Steps       string `json:"steps"`
+	ID           string `json:"id"`
+	Name         string `json:"name_recipe"`
+	Description  string `json:"description"`
+	MealTypeID   string `json:"meal_type_id"`
+	ImgURL       string `json:"img_url"`
+	ImgDetailURL string `json:"img_detail_url"`
+	CreatorName  string `json:"creator_name"`
+	Rating       string `json:"rating"`
+	Steps        string `json:"steps"`
+	// Images is the gallery, in order, the cover is also in ImgURL
+	Images []RecipeImage `json:"images"`
+}
+
+type RecipeImage struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	CardURL  string `json:"card_url"`
+	Caption  string `json:"caption"`
+	Position int    `json:"position"`
+	IsCover  bool   `json:"is_cover"`
 }
 
 type RecipesHandler struct {
 	DB db.DBExecutor
 	SecretKey string
+	// Images stores uploaded recipe photos
+	Images lib.ImageStore
 }
 
 func NewRecipesHandler(db db.DBExecutor, secret string) *RecipesHandler {
 	return &RecipesHandler{DB: db, SecretKey: secret}
 }
+
+//Type crafted with the following feed in mind
+type FeedRecipe struct {
+	ID          string `json:"id"`
+	Name        string `json:"name_recipe"`
+	Description string `json:"description"`
+	MealTypeID  string `json:"meal_type_id"`
+	ImgURL      string `json:"img_url"`
+	ImgCardURL  string `json:"img_card_url"`
+	AuthorID    string `json:"author_id"`
+	AuthorName  string `json:"author_name"`
+	Rating      string `json:"rating"`
+	CreatedAt   string `json:"created_at"`
+}
@@ -0,0 +1,95 @@
+package stream
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/realtime"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+// Stream pushes Server-Sent Events: new comments of ?recipe=<id> and, with a token, the
+// caller's notifications. Reconnecting clients resume from the Last-Event-ID header.
+func (h *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var topics []string
+	if recipeID := r.URL.Query().Get("recipe"); recipeID != "" {
+		if _, err := strconv.Atoi(recipeID); err != nil {
+			http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+			return
+		}
+		topics = append(topics, realtime.RecipeTopic(recipeID))
+	}
+
+	if userID, err := utils.ParseToken(r, h.SecretKey); err == nil {
+		topics = append(topics, realtime.UserTopic(userID))
+	}
+
+	if len(topics) == 0 {
+		http.Error(w, "Nothing to stream, pass a recipe or log in", http.StatusBadRequest)
+		return
+	}
+
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	// The stream outlives any server write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	client, replay, complete := h.Hub.Subscribe(topics, lastID)
+	defer h.Hub.Unsubscribe(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", RetryMillis)
+	if !complete {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", EventResync)
+	}
+	for _, msg := range replay {
+		writeMessage(w, msg)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case msg, ok := <-client.C:
+			if !ok {
+				// Dropped for falling behind, the client reconnects and resumes
+				return
+			}
+			writeMessage(w, msg)
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeMessage(w http.ResponseWriter, msg realtime.Message) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\n", msg.ID, msg.Event)
+	for _, line := range strings.Split(string(msg.Data), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
@@ -0,0 +1,133 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/events"
+	"github.com/Zheng5005/BiteBox/realtime"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+// readEvent reads lines up to the end of the next event that has an id
+func readEvent(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(lines) > 0 && strings.HasPrefix(lines[0], "id: ") {
+				return lines
+			}
+			lines = nil
+			continue
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestStream_NothingToStream(t *testing.T) {
+	handler := NewStreamHandler(nil, "other_key", realtime.NewHub())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stream", nil)
+	rr := httptest.NewRecorder()
+
+	handler.Stream(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %d", rr.Code)
+	}
+}
+
+func TestStream_ResumesAndPushes(t *testing.T) {
+	hub := realtime.NewHub()
+	handler := NewStreamHandler(nil, "other_key", hub)
+	handler.Heartbeat = time.Hour
+
+	hub.Publish(realtime.UserTopic("5"), EventNotification, []byte(`{"id":"1"}`))
+	hub.Publish(realtime.UserTopic("5"), EventNotification, []byte(`{"id":"2"}`))
+
+	server := httptest.NewServer(http.HandlerFunc(handler.Stream))
+	defer server.Close()
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"?recipe=3", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Last-Event-ID", "1")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	body := bufio.NewReader(res.Body)
+
+	// Missed while disconnected
+	got := readEvent(t, body)
+	if strings.Join(got, "|") != `id: 2|event: notification|data: {"id":"2"}` {
+		t.Errorf("unexpected replayed event: %v", got)
+	}
+
+	// Published live, the recipe being viewed
+	hub.Publish(realtime.RecipeTopic("3"), EventComment, []byte(`{"id":"9"}`))
+	got = readEvent(t, body)
+	if strings.Join(got, "|") != `id: 3|event: comment|data: {"id":"9"}` {
+		t.Errorf("unexpected live event: %v", got)
+	}
+}
+
+func TestSubscribe_PublishesNewComment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM comments c LEFT JOIN users u ON u.id = c.user_id WHERE c.id = $1")).
+		WithArgs("9").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "recipe_id", "comment", "rating"}).AddRow("9", "Bob", "3", "Tasty", "5"))
+
+	hub := realtime.NewHub()
+	client, _, _ := hub.Subscribe([]string{realtime.RecipeTopic("3")}, 0)
+	defer hub.Unsubscribe(client)
+
+	dispatcher := events.NewDispatcher()
+	NewStreamHandler(db, "other_key", hub).Subscribe(dispatcher)
+
+	dispatcher.Publish(context.Background(), events.Event{
+		Type:       events.CommentCreated,
+		ActorID:    "7",
+		TargetType: "recipe",
+		TargetID:   "3",
+		Data:       map[string]string{"comment_id": "9"},
+	})
+
+	select {
+	case msg := <-client.C:
+		if msg.Event != EventComment || !strings.Contains(string(msg.Data), `"user_name":"Bob"`) {
+			t.Errorf("unexpected message: %s %s", msg.Event, msg.Data)
+		}
+	default:
+		t.Error("expected the comment on the recipe topic")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
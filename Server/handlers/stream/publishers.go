@@ -0,0 +1,48 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Zheng5005/BiteBox/events"
+	"github.com/Zheng5005/BiteBox/handlers/comments"
+	"github.com/Zheng5005/BiteBox/realtime"
+)
+
+// Subscribe forwards the events clients can watch to the hub
+func (h *StreamHandler) Subscribe(d *events.Dispatcher) {
+	d.Subscribe(events.CommentCreated, h.publishComment)
+	d.Subscribe(events.RecipeRated, h.publishComment)
+	d.Subscribe(events.NotificationCreated, h.publishNotification)
+}
+
+// publishComment sends the comment as GET /api/comments/ lists it
+func (h *StreamHandler) publishComment(ctx context.Context, e events.Event) error {
+	var c comments.Comment
+	err := h.DB.QueryRowContext(ctx,
+		"SELECT c.id, COALESCE(u.name, 'Deleted user'), c.recipe_id, c.comment, c.rating FROM comments c LEFT JOIN users u ON u.id = c.user_id WHERE c.id = $1",
+		e.Data["comment_id"],
+	).Scan(&c.ID, &c.UserID, &c.RecipeID, &c.Comment, &c.Rating)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	h.Hub.Publish(realtime.RecipeTopic(c.RecipeID), EventComment, data)
+	return nil
+}
+
+// publishNotification only says there is something new, clients fetch GET /api/notifications
+func (h *StreamHandler) publishNotification(ctx context.Context, e events.Event) error {
+	data, err := json.Marshal(map[string]string{"id": e.Data["id"], "type": e.Data["type"]})
+	if err != nil {
+		return err
+	}
+
+	h.Hub.Publish(realtime.UserTopic(e.Data["user_id"]), EventNotification, data)
+	return nil
+}
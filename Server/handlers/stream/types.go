@@ -0,0 +1,34 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/realtime"
+)
+
+const (
+	// HeartbeatInterval keeps proxies from closing idle streams
+	HeartbeatInterval = 25 * time.Second
+	// RetryMillis is how long browsers wait before reconnecting
+	RetryMillis = 3000
+)
+
+// Event names sent on the stream
+const (
+	EventComment      = "comment"
+	EventNotification = "notification"
+	// EventResync tells the client it missed messages that can't be replayed and should reload
+	EventResync = "resync"
+)
+
+type StreamHandler struct {
+	DB        db.DBExecutor
+	SecretKey string
+	Hub       *realtime.Hub
+	Heartbeat time.Duration
+}
+
+func NewStreamHandler(db db.DBExecutor, secret string, hub *realtime.Hub) *StreamHandler {
+	return &StreamHandler{DB: db, SecretKey: secret, Hub: hub, Heartbeat: HeartbeatInterval}
+}
@@ -0,0 +1,487 @@
+package users
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/logging"
+)
+
+const (
+	// DeletionGracePeriod is how long a deletion can still be cancelled
+	DeletionGracePeriod = 14 * 24 * time.Hour
+	// ExportTTL is how long a finished archive can be downloaded
+	ExportTTL = 7 * 24 * time.Hour
+	// DeletedUserName replaces the author of content left behind by a deleted account
+	DeletedUserName = "Deleted user"
+)
+
+// exportSections are the files of the archive, each one is the result of its query for the user
+var exportSections = []struct {
+	Name  string
+	Query string
+}{
+	{"profile", "SELECT id, name, email, url_photo, bio, role, email_verified, totp_enabled, deletion_scheduled_at FROM users WHERE id = $1"},
+	{"recipes", "SELECT id, name_recipe, description, meal_type_id, img_url, steps, is_active FROM recipes WHERE user_id = $1 ORDER BY id"},
+	{"comments", "SELECT id, recipe_id, comment, rating, is_hidden FROM comments WHERE user_id = $1 ORDER BY id"},
+	{"ratings", "SELECT recipe_id, rating FROM comments WHERE user_id = $1 AND rating IS NOT NULL ORDER BY id"},
+	{"following", "SELECT followee_id, created_at FROM follows WHERE follower_id = $1 ORDER BY created_at"},
+	{"followers", "SELECT follower_id, created_at FROM follows WHERE followee_id = $1 ORDER BY created_at"},
+	{"notifications", "SELECT type, target_type, target_id, data, created_at, read_at FROM notifications WHERE user_id = $1 ORDER BY id"},
+	{"reports", "SELECT target_type, target_id, reason, details, status, created_at FROM reports WHERE reporter_id = $1 ORDER BY id"},
+	{"identities", "SELECT provider, email, created_at FROM identities WHERE user_id = $1 ORDER BY id"},
+	{"sessions", "SELECT device, ip, user_agent, created_at, last_seen_at, revoked_at FROM sessions WHERE user_id = $1 ORDER BY created_at"},
+}
+
+func (h *UserHandler) RequestExportAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// One export in flight per user, asking again while it runs just returns it
+	var export DataExport
+	err = h.DB.QueryRowContext(r.Context(), `
+		SELECT id, status, created_at FROM data_exports
+		WHERE user_id = $1 AND status IN ('pending', 'processing')`, userID,
+	).Scan(&export.ID, &export.Status, &export.CreatedAt)
+	if err == sql.ErrNoRows {
+		err = h.DB.QueryRowContext(r.Context(),
+			"INSERT INTO data_exports (user_id) VALUES ($1) RETURNING id, status, created_at", userID,
+		).Scan(&export.ID, &export.Status, &export.CreatedAt)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Failed to request export", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(export)
+}
+
+func (h *UserHandler) GetExportsAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT id, status, created_at, COALESCE(completed_at::text, ''), COALESCE(expires_at::text, '')
+		FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	exports := []DataExport{}
+
+	for rows.Next() {
+		var e DataExport
+		if err := rows.Scan(&e.ID, &e.Status, &e.CreatedAt, &e.CompletedAt, &e.ExpiresAt); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		exports = append(exports, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(exports)
+}
+
+func (h *UserHandler) DownloadExportAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	id := r.PathValue("id")
+
+	var path string
+	err = h.DB.QueryRowContext(r.Context(), `
+		SELECT file_path FROM data_exports
+		WHERE id = $1 AND user_id = $2 AND status = 'ready' AND expires_at > NOW()`, id, userID,
+	).Scan(&path)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Export file error", "err", err)
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="bitebox-export-%s.zip"`, id))
+	http.ServeContent(w, r, "export.zip", info.ModTime(), file)
+}
+
+// DeleteAccountAuth schedules the deletion, the account keeps working until the grace period ends
+func (h *UserHandler) DeleteAccountAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, sessionID, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+		TOTPCode string `json:"totp_code"`
+	}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	if err := h.checkCurrentPassword(r.Context(), userID, sessionID, input.Password, input.TOTPCode); err != nil {
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			http.Error(w, "Password is incorrect", http.StatusUnauthorized)
+		} else if err == errStepUpRequired {
+			http.Error(w, stepUpMessage, http.StatusForbidden)
+		} else {
+			logging.FromContext(r.Context()).Error("DB error", "err", err)
+			http.Error(w, "Failed to schedule deletion", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	scheduledAt := time.Now().Add(DeletionGracePeriod)
+	res, err := h.DB.ExecContext(r.Context(),
+		"UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2 AND deletion_scheduled_at IS NULL",
+		scheduledAt, userID,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to schedule deletion", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "Deletion already scheduled", http.StatusConflict)
+		return
+	}
+
+	// Only the device that asked stays logged in, to be able to cancel
+	_, err = h.DB.ExecContext(r.Context(),
+		"UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL",
+		userID, sessionID,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]time.Time{"deletion_scheduled_at": scheduledAt})
+}
+
+func (h *UserHandler) CancelDeletionAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(),
+		"UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at > NOW()",
+		userID,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to cancel deletion", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		http.Error(w, "No deletion scheduled", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Deletion cancelled"))
+}
+
+// ProcessExports builds the pending archives one by one and drops the expired ones
+func (h *UserHandler) ProcessExports(ctx context.Context) error {
+	// Exports left in processing by a crashed worker go back to the queue
+	_, err := h.DB.ExecContext(ctx,
+		"UPDATE data_exports SET status = 'pending' WHERE status = 'processing' AND started_at < NOW() - INTERVAL '1 hour'",
+	)
+	if err != nil {
+		return err
+	}
+
+	for ctx.Err() == nil {
+		var exportID, userID string
+		err := h.DB.QueryRowContext(ctx, `
+			UPDATE data_exports SET status = 'processing', started_at = NOW()
+			WHERE id = (
+				SELECT id FROM data_exports WHERE status = 'pending'
+				ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, user_id`,
+		).Scan(&exportID, &userID)
+		if err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		path, err := h.writeExport(ctx, exportID, userID)
+		if err != nil {
+			logging.FromContext(ctx).Error("Export error", "err", err)
+			_, err = h.DB.ExecContext(ctx, "UPDATE data_exports SET status = 'failed', error = $2 WHERE id = $1", exportID, err.Error())
+		} else {
+			_, err = h.DB.ExecContext(ctx,
+				"UPDATE data_exports SET status = 'ready', file_path = $2, completed_at = NOW(), expires_at = $3 WHERE id = $1",
+				exportID, path, time.Now().Add(ExportTTL),
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err := h.DB.QueryContext(ctx,
+		"UPDATE data_exports SET status = 'expired' WHERE status = 'ready' AND expires_at <= NOW() RETURNING file_path",
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return err
+		}
+		removeExportFile(ctx, path)
+	}
+
+	return rows.Err()
+}
+
+// writeExport writes one JSON file per section into a ZIP archive and returns its path
+func (h *UserHandler) writeExport(ctx context.Context, exportID, userID string) (string, error) {
+	if err := os.MkdirAll(h.ExportDir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(h.ExportDir, fmt.Sprintf("export-%s-user-%s.zip", exportID, userID))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	for _, section := range exportSections {
+		records, err := h.queryRecords(ctx, section.Query, userID)
+		if err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("%s: %w", section.Name, err)
+		}
+
+		entry, err := archive.Create(section.Name + ".json")
+		if err != nil {
+			os.Remove(path)
+			return "", err
+		}
+
+		enc := json.NewEncoder(entry)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(records); err != nil {
+			os.Remove(path)
+			return "", err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// queryRecords returns the rows as column name to value maps, NULL becomes null
+func (h *UserHandler) queryRecords(ctx context.Context, query string, args ...any) ([]map[string]any, error) {
+	rows, err := h.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []map[string]any{}
+
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		record := map[string]any{}
+		for i, column := range columns {
+			if values[i].Valid {
+				record[column] = values[i].String
+			} else {
+				record[column] = nil
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// PurgeDeletedAccounts deletes the accounts whose grace period ended. Their recipes stay up
+// credited to DeletedUserName and their comments lose their author, everything else goes with the user.
+func (h *UserHandler) PurgeDeletedAccounts(ctx context.Context) error {
+	rows, err := h.DB.QueryContext(ctx, "SELECT id FROM users WHERE deletion_scheduled_at <= NOW()")
+	if err != nil {
+		return err
+	}
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := h.purgeAccount(ctx, userID); err != nil {
+			logging.FromContext(ctx).Error("Purge error", "user_id", userID, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// purgeAccount runs in one transaction that holds the user row, so a deletion cancelled meanwhile
+// either waits for the purge or leaves the account intact
+func (h *UserHandler) purgeAccount(ctx context.Context, userID string) error {
+	var paths []string
+	err := db.InTx(ctx, h.DB, func(tx db.Querier) error {
+		var id string
+		err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = $1 AND deletion_scheduled_at <= NOW() FOR UPDATE", userID).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE recipes SET user_id = NULL, guest_name = $2 WHERE user_id = $1", userID, DeletedUserName); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE comments SET user_id = NULL WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+
+		files, err := tx.QueryContext(ctx, "SELECT file_path FROM data_exports WHERE user_id = $1 AND file_path IS NOT NULL", userID)
+		if err != nil {
+			return err
+		}
+		for files.Next() {
+			var path string
+			if err := files.Scan(&path); err != nil {
+				files.Close()
+				return err
+			}
+			paths = append(paths, path)
+		}
+		files.Close()
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		// Cancelled before the purge got to it
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Files only go once the rows are gone for good
+	for _, path := range paths {
+		removeExportFile(ctx, path)
+	}
+
+	return nil
+}
+
+func removeExportFile(ctx context.Context, path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logging.FromContext(ctx).Error("Export file error", "err", err)
+	}
+}
@@ -0,0 +1,223 @@
+package users
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestRequestExport_QueuesNewExport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, status, created_at FROM data_exports")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "created_at"}))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO data_exports (user_id) VALUES ($1)")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "created_at"}).AddRow("12", "pending", "2025-01-01T00:00:00Z"))
+
+	token, _ := utils.GenerateMockSessionJWT("5", "sess-1", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/me/export", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.RequestExportAuth(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 Accepted, got %d", rr.Code)
+	}
+
+	var got DataExport
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil || got.ID != "12" || got.Status != "pending" {
+		t.Errorf("Unexpected export: %+v", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestProcessExports_WritesArchive(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewUserHandler(db, "other_key")
+	handler.ExportDir = t.TempDir()
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE data_exports SET status = 'pending' WHERE status = 'processing'")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE data_exports SET status = 'processing'")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("12", "5"))
+	for _, section := range exportSections {
+		rows := sqlmock.NewRows([]string{"id", "name"})
+		if section.Name == "profile" {
+			rows.AddRow("5", "Jane")
+		}
+		mock.ExpectQuery(regexp.QuoteMeta(section.Query)).WithArgs("5").WillReturnRows(rows)
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE data_exports SET status = 'ready'")).
+		WithArgs("12", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE data_exports SET status = 'processing'")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE data_exports SET status = 'expired'")).
+		WillReturnRows(sqlmock.NewRows([]string{"file_path"}))
+
+	if err := handler.ProcessExports(context.Background()); err != nil {
+		t.Fatalf("ProcessExports failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+
+	archivePath := filepath.Join(handler.ExportDir, "export-12-user-5.zip")
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatalf("Expected archive at %s: %v", archivePath, err)
+	}
+	defer archive.Close()
+
+	if len(archive.File) != len(exportSections) {
+		t.Fatalf("Expected %d files in the archive, got %d", len(exportSections), len(archive.File))
+	}
+
+	profile, err := archive.Open("profile.json")
+	if err != nil {
+		t.Fatalf("Expected profile.json in the archive: %v", err)
+	}
+	defer profile.Close()
+
+	data, _ := io.ReadAll(profile)
+	if !strings.Contains(string(data), `"name": "Jane"`) {
+		t.Errorf("Expected profile data in profile.json, got %s", data)
+	}
+}
+
+func TestDeleteAccount_SchedulesDeletion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT password FROM users WHERE id = $1")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(nil))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users u JOIN sessions s ON s.user_id = u.id")).
+		WithArgs("5", "sess-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"recent", "totp_secret"}).AddRow(true, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2 AND deletion_scheduled_at IS NULL")).
+		WithArgs(sqlmock.AnyArg(), "5").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2")).
+		WithArgs("5", "sess-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	token, _ := utils.GenerateMockSessionJWT("5", "sess-1", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/users/me", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.DeleteAccountAuth(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 Accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPurgeDeletedAccounts_AnonymizesContent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE deletion_scheduled_at <= NOW()")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE id = $1 AND deletion_scheduled_at <= NOW() FOR UPDATE")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipes SET user_id = NULL, guest_name = $2")).
+		WithArgs("5", DeletedUserName).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE comments SET user_id = NULL")).
+		WithArgs("5").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT file_path FROM data_exports")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path"}))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM users WHERE id = $1")).
+		WithArgs("5").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handler := NewUserHandler(db, "other_key")
+	if err := handler.PurgeDeletedAccounts(context.Background()); err != nil {
+		t.Fatalf("PurgeDeletedAccounts failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPurgeDeletedAccounts_FailureKeepsAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE deletion_scheduled_at <= NOW()")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE id = $1 AND deletion_scheduled_at <= NOW() FOR UPDATE")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("5"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE recipes SET user_id = NULL, guest_name = $2")).
+		WithArgs("5", DeletedUserName).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE comments SET user_id = NULL")).
+		WithArgs("5").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	handler := NewUserHandler(db, "other_key")
+	if err := handler.PurgeDeletedAccounts(context.Background()); err != nil {
+		t.Fatalf("PurgeDeletedAccounts failed: %v", err)
+	}
+
+	// The recipes are not left anonymized under a live account
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
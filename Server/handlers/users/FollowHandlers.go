@@ -0,0 +1,161 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Zheng5005/BiteBox/events"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+const (
+	DefaultFollowPageSize = 50
+	MaxFollowPageSize     = 100
+)
+
+func (h *UserHandler) FollowAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := strconv.Atoi(id); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if id == userID {
+		http.Error(w, "You can't follow yourself", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(), `
+		INSERT INTO follows (follower_id, followee_id)
+		SELECT $1, u.id FROM users u WHERE u.id = $2 AND u.deletion_scheduled_at IS NULL
+		ON CONFLICT DO NOTHING`, userID, id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB insert error", "err", err)
+		http.Error(w, "Failed to follow user", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count == 0 {
+		// Nothing inserted means either already following or no such user
+		var following bool
+		err := h.DB.QueryRowContext(r.Context(),
+			"SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2)", userID, id,
+		).Scan(&following)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("DB error", "err", err)
+			http.Error(w, "Failed to follow user", http.StatusInternalServerError)
+			return
+		}
+		if !following {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		h.Events.Publish(r.Context(), events.Event{Type: events.UserFollowed, ActorID: userID, TargetType: "user", TargetID: id})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Following"))
+}
+
+func (h *UserHandler) UnfollowAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := h.DB.ExecContext(r.Context(), "DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2", userID, id); err != nil {
+		logging.FromContext(r.Context()).Error("DB delete error", "err", err)
+		http.Error(w, "Failed to unfollow user", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Unfollowed"))
+}
+
+func (h *UserHandler) GetFollowers(w http.ResponseWriter, r *http.Request) {
+	h.listFollows(w, r, `
+		SELECT u.id, u.name, COALESCE(u.url_photo, ''), f.created_at
+		FROM follows f
+		JOIN users u ON u.id = f.follower_id
+		WHERE f.followee_id = $1
+		ORDER BY f.created_at DESC
+		LIMIT $2 OFFSET $3`)
+}
+
+func (h *UserHandler) GetFollowing(w http.ResponseWriter, r *http.Request) {
+	h.listFollows(w, r, `
+		SELECT u.id, u.name, COALESCE(u.url_photo, ''), f.created_at
+		FROM follows f
+		JOIN users u ON u.id = f.followee_id
+		WHERE f.follower_id = $1
+		ORDER BY f.created_at DESC
+		LIMIT $2 OFFSET $3`)
+}
+
+// listFollows runs one of the follow list queries for the user in the path, paged with ?limit and ?offset
+func (h *UserHandler) listFollows(w http.ResponseWriter, r *http.Request, query string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := strconv.Atoi(id); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	limit := DefaultFollowPageSize
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = min(l, MaxFollowPageSize)
+	}
+	offset := 0
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), query, id, limit, offset)
+	if err != nil {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []FollowUser{}
+
+	for rows.Next() {
+		var u FollowUser
+		if err := rows.Scan(&u.ID, &u.Name, &u.URLPhoto, &u.FollowedAt); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		users = append(users, u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
@@ -0,0 +1,121 @@
+package users
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestFollow_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO follows (follower_id, followee_id)")).
+		WithArgs("5", "7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/follow/7", nil)
+	req.SetPathValue("id", "7")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.FollowAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestFollow_Self(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/follow/5", nil)
+	req.SetPathValue("id", "5")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.FollowAuth(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %d", rr.Code)
+	}
+}
+
+func TestFollow_UnknownUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO follows (follower_id, followee_id)")).
+		WithArgs("5", "99").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM follows")).
+		WithArgs("5", "99").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/follow/99", nil)
+	req.SetPathValue("id", "99")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.FollowAuth(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 Not Found, got %d", rr.Code)
+	}
+}
+
+func TestGetFollowers_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("JOIN users u ON u.id = f.follower_id")).
+		WithArgs("7", 10, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "url_photo", "created_at"}).
+			AddRow("5", "Jane", "", "2025-01-01T00:00:00Z"))
+
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/7/followers?limit=10&offset=20", nil)
+	req.SetPathValue("id", "7")
+	rr := httptest.NewRecorder()
+
+	handler.GetFollowers(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+// GetIdentitiesAuth lists the login methods of the user, so the client knows which ones can be removed
+func (h *UserHandler) GetIdentitiesAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var hasPassword bool
+	if err := h.DB.QueryRowContext(r.Context(), "SELECT password IS NOT NULL FROM users WHERE id = $1", userID).Scan(&hasPassword); err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT provider, COALESCE(email, ''), created_at
+		FROM identities
+		WHERE user_id = $1
+		ORDER BY created_at`, userID)
+	if err != nil {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.Provider, &i.Email, &i.CreatedAt); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		identities = append(identities, i)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"has_password": hasPassword,
+		"identities":   identities,
+	})
+}
+
+func (h *UserHandler) UnlinkIdentityAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	provider := r.PathValue("provider")
+	if provider == "" {
+		http.Error(w, "Missing provider", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ParseToken(r, h.SecretKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// The check and the delete are one statement, so two concurrent unlinks can't leave the account without a way in
+	res, err := h.DB.ExecContext(r.Context(), `
+		DELETE FROM identities i
+		WHERE i.user_id = $1 AND i.provider = $2
+			AND (
+				EXISTS (SELECT 1 FROM users u WHERE u.id = i.user_id AND u.password IS NOT NULL)
+				OR EXISTS (SELECT 1 FROM identities o WHERE o.user_id = i.user_id AND o.provider <> i.provider)
+			)`, userID, provider)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB delete error", "err", err)
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+
+	if count, _ := res.RowsAffected(); count > 0 {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Account unlinked"))
+		return
+	}
+
+	var linked bool
+	err = h.DB.QueryRowContext(r.Context(),
+		"SELECT EXISTS (SELECT 1 FROM identities WHERE user_id = $1 AND provider = $2)",
+		userID, provider,
+	).Scan(&linked)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+
+	if !linked {
+		http.Error(w, "Login method not found", http.StatusNotFound)
+		return
+	}
+
+	http.Error(w, "Set a password or link another login method first", http.StatusConflict)
+}
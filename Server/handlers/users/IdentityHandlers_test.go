@@ -0,0 +1,120 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestGetIdentities_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT password IS NOT NULL FROM users")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"has_password"}).AddRow(false))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM identities")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"provider", "email", "created_at"}).
+			AddRow("google", "jane@gmail.com", "2025-01-01T00:00:00Z"))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/identities", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.GetIdentitiesAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	var got struct {
+		HasPassword bool       `json:"has_password"`
+		Identities  []Identity `json:"identities"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+
+	if got.HasPassword || len(got.Identities) != 1 || got.Identities[0].Provider != "google" {
+		t.Errorf("Unexpected identities: %+v", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUnlinkIdentity_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM identities i")).
+		WithArgs("5", "google").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/users/identities/google", nil)
+	req.SetPathValue("provider", "google")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.UnlinkIdentityAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUnlinkIdentity_LastLoginMethod(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM identities i")).
+		WithArgs("5", "google").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM identities")).
+		WithArgs("5", "google").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/users/identities/google", nil)
+	req.SetPathValue("provider", "google")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.UnlinkIdentityAuth(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 Conflict, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Zheng5005/BiteBox/assets"
+	"github.com/Zheng5005/BiteBox/imaging"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MaxNameLength = 100
+	MaxBioLength  = 500
+	// RecentLoginWindow is how long after signing in an account without a password
+	// can set one or schedule its deletion without a second factor
+	RecentLoginWindow = 10 * time.Minute
+)
+
+// errStepUpRequired is returned for an account without a password that hasn't
+// signed in recently and gave no valid authenticator code
+var errStepUpRequired = errors.New("recent sign in or authenticator code required")
+
+const stepUpMessage = "Sign in again, or enter a code from your authenticator app, to continue"
+
+// GetProfile is the public view of a user, only active recipes and visible ratings count
+func (h *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := strconv.Atoi(id); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var p Profile
+	err := h.DB.QueryRowContext(r.Context(), `
+		SELECT
+			u.id,
+			u.name,
+			COALESCE(u.url_photo, ''),
+			COALESCE(u.bio, ''),
+			(SELECT COUNT(*) FROM recipes r WHERE r.user_id = u.id AND r.is_active = true),
+			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0),
+			COUNT(c.rating),
+			(SELECT COUNT(*) FROM follows f WHERE f.followee_id = u.id),
+			(SELECT COUNT(*) FROM follows f WHERE f.follower_id = u.id)
+		FROM users u
+		LEFT JOIN recipes r ON r.user_id = u.id AND r.is_active = true
+		LEFT JOIN comments c ON c.recipe_id = r.id AND c.is_hidden = false
+		WHERE u.id = $1
+		GROUP BY u.id`, id,
+	).Scan(&p.ID, &p.Name, &p.URLPhoto, &p.Bio, &p.RecipeCount, &p.AverageRating, &p.RatingCount, &p.FollowerCount, &p.FollowingCount)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// UpdateProfileAuth edits the caller's own profile, only the fields sent are changed.
+// A new password needs the current one, and logs out every other session.
+func (h *UserHandler) UpdateProfileAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, sessionID, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	updateFields := []string{}
+	args := []interface{}{}
+	i := 1
+
+	if name, ok := r.MultipartForm.Value["name"]; ok {
+		n := strings.TrimSpace(name[0])
+		if n == "" || utf8.RuneCountInString(n) > MaxNameLength {
+			http.Error(w, fmt.Sprintf("Name must be 1 to %d characters", MaxNameLength), http.StatusBadRequest)
+			return
+		}
+		updateFields = append(updateFields, fmt.Sprintf("name = $%d", i))
+		args = append(args, n)
+		i++
+	}
+
+	// An empty bio clears it
+	if bio, ok := r.MultipartForm.Value["bio"]; ok {
+		b := strings.TrimSpace(bio[0])
+		if utf8.RuneCountInString(b) > MaxBioLength {
+			http.Error(w, fmt.Sprintf("Bio must be at most %d characters", MaxBioLength), http.StatusBadRequest)
+			return
+		}
+		updateFields = append(updateFields, fmt.Sprintf("bio = $%d", i))
+		args = append(args, b)
+		i++
+	}
+
+	newPassword := r.FormValue("new_password")
+	if newPassword != "" {
+		if err := h.checkCurrentPassword(r.Context(), userID, sessionID, r.FormValue("current_password"), r.FormValue("totp_code")); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+			} else if err == errStepUpRequired {
+				http.Error(w, stepUpMessage, http.StatusForbidden)
+			} else {
+				logging.FromContext(r.Context()).Error("DB error", "err", err)
+				http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Error hashing password", http.StatusInternalServerError)
+			return
+		}
+		updateFields = append(updateFields, fmt.Sprintf("password = $%d", i))
+		args = append(args, hashedPassword)
+		i++
+	}
+
+	//Process and store the uploaded image
+	file, _, err := r.FormFile("image")
+	if err == nil {
+		defer file.Close()
+
+		urls, err := imaging.Save(assets.WithOwner(r.Context(), userID), h.Images, file, imaging.Avatar)
+		if imaging.Rejected(err) {
+			http.Error(w, "Invalid image: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logging.FromContext(r.Context()).Error("Image upload error", "err", err)
+			http.Error(w, "Error uploading image", http.StatusInternalServerError)
+			return
+		}
+		imageURL := urls[imaging.Avatar.Name]
+		updateFields = append(updateFields, fmt.Sprintf("url_photo = $%d", i))
+		args = append(args, imageURL)
+		i++
+	} else if err != http.ErrMissingFile {
+		http.Error(w, "Error reading file", http.StatusBadRequest)
+		return
+	}
+
+	if len(updateFields) == 0 {
+		http.Error(w, "No valid fields to update", http.StatusBadRequest)
+		return
+	}
+
+	args = append(args, userID)
+	query := fmt.Sprintf("UPDATE users SET %s WHERE id = $%d", strings.Join(updateFields, ", "), i)
+
+	if _, err := h.DB.ExecContext(r.Context(), query, args...); err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+
+	if newPassword != "" {
+		_, err := h.DB.ExecContext(r.Context(),
+			"UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL",
+			userID, sessionID,
+		)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Profile updated"))
+}
+
+// checkCurrentPassword returns bcrypt.ErrMismatchedHashAndPassword when it doesn't match.
+// Accounts created through an external login have none to confirm, they need a session signed
+// in within RecentLoginWindow or a code from their authenticator app, errStepUpRequired otherwise.
+func (h *UserHandler) checkCurrentPassword(ctx context.Context, userID, sessionID, current, totpCode string) error {
+	var hashedPassword sql.NullString
+	if err := h.DB.QueryRowContext(ctx, "SELECT password FROM users WHERE id = $1", userID).Scan(&hashedPassword); err != nil {
+		return err
+	}
+
+	if hashedPassword.Valid {
+		return bcrypt.CompareHashAndPassword([]byte(hashedPassword.String), []byte(current))
+	}
+
+	// Refreshing keeps the session, so its age is the time since the user last signed in
+	var recent bool
+	var totpSecret sql.NullString
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT s.created_at > $3, CASE WHEN u.totp_enabled THEN u.totp_secret END
+		FROM users u JOIN sessions s ON s.user_id = u.id
+		WHERE u.id = $1 AND s.id = $2`,
+		userID, sessionID, time.Now().Add(-RecentLoginWindow),
+	).Scan(&recent, &totpSecret)
+	if err == sql.ErrNoRows {
+		return errStepUpRequired
+	} else if err != nil {
+		return err
+	}
+
+	if recent {
+		return nil
+	}
+
+	if !totpSecret.Valid {
+		return errStepUpRequired
+	}
+
+	step, ok := utils.ValidateTOTP(totpSecret.String, totpCode, time.Now())
+	if !ok {
+		return errStepUpRequired
+	}
+
+	// A code is only good once, same as at login
+	res, err := h.DB.ExecContext(ctx,
+		"UPDATE users SET totp_last_step = $2 WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)",
+		userID, step,
+	)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return errStepUpRequired
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// profileForm builds a multipart PATCH /api/users/me request
+func profileForm(t *testing.T, fields map[string]string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for k, v := range fields {
+		writer.WriteField(k, v)
+	}
+	writer.Close()
+
+	token, _ := utils.GenerateMockSessionJWT("5", "sess-1", "other_key")
+	req := httptest.NewRequest(http.MethodPatch, "/api/users/me", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestGetProfile_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users u")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "url_photo", "bio", "recipe_count", "avg", "rating_count", "followers", "following"}).
+			AddRow("5", "Jane", "photo.jpg", "Loves soup", 3, "4.50", 8, 12, 4))
+
+	handler := NewUserHandler(db, "other_key")
+	req := httptest.NewRequest(http.MethodGet, "/api/users/5", nil)
+	req.SetPathValue("id", "5")
+	rr := httptest.NewRecorder()
+
+	handler.GetProfile(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	var got Profile
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+
+	if got.Name != "Jane" || got.RecipeCount != 3 || got.AverageRating != "4.50" || got.RatingCount != 8 || got.FollowerCount != 12 {
+		t.Errorf("Unexpected profile: %+v", got)
+	}
+}
+
+func TestGetProfile_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users u")).
+		WithArgs("99").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	handler := NewUserHandler(db, "other_key")
+	req := httptest.NewRequest(http.MethodGet, "/api/users/99", nil)
+	req.SetPathValue("id", "99")
+	rr := httptest.NewRecorder()
+
+	handler.GetProfile(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 Not Found, got %d", rr.Code)
+	}
+}
+
+func TestUpdateProfile_NameAndBio(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET name = $1, bio = $2 WHERE id = $3")).
+		WithArgs("Jane D.", "", "5").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := NewUserHandler(db, "other_key")
+	rr := httptest.NewRecorder()
+	handler.UpdateProfileAuth(rr, profileForm(t, map[string]string{"name": " Jane D. ", "bio": ""}))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUpdateProfile_PasswordRevokesOtherSessions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("old-pass"), bcrypt.DefaultCost)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT password FROM users WHERE id = $1")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(string(hashed)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET password = $1 WHERE id = $2")).
+		WithArgs(sqlmock.AnyArg(), "5").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2")).
+		WithArgs("5", "sess-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	handler := NewUserHandler(db, "other_key")
+	rr := httptest.NewRecorder()
+	handler.UpdateProfileAuth(rr, profileForm(t, map[string]string{"current_password": "old-pass", "new_password": "new-pass"}))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUpdateProfile_NoPasswordNeedsStepUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	// Signed in with Google an hour ago, no authenticator set up
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT password FROM users WHERE id = $1")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(nil))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users u JOIN sessions s ON s.user_id = u.id")).
+		WithArgs("5", "sess-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"recent", "totp_secret"}).AddRow(false, nil))
+
+	handler := NewUserHandler(db, "other_key")
+	rr := httptest.NewRecorder()
+	handler.UpdateProfileAuth(rr, profileForm(t, map[string]string{"new_password": "new-pass"}))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 Forbidden, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUpdateProfile_NoPasswordWithAuthenticatorCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	secret, _ := utils.NewTOTPSecret()
+	step := utils.TOTPStep(time.Now())
+	code, _ := utils.TOTPCode(secret, step)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT password FROM users WHERE id = $1")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(nil))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users u JOIN sessions s ON s.user_id = u.id")).
+		WithArgs("5", "sess-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"recent", "totp_secret"}).AddRow(false, secret))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET totp_last_step = $2")).
+		WithArgs("5", step).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET password = $1 WHERE id = $2")).
+		WithArgs(sqlmock.AnyArg(), "5").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2")).
+		WithArgs("5", "sess-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	handler := NewUserHandler(db, "other_key")
+	rr := httptest.NewRecorder()
+	handler.UpdateProfileAuth(rr, profileForm(t, map[string]string{"totp_code": code, "new_password": "new-pass"}))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestUpdateProfile_WrongCurrentPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	hashed, _ := bcrypt.GenerateFromPassword([]byte("old-pass"), bcrypt.DefaultCost)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT password FROM users WHERE id = $1")).
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(string(hashed)))
+
+	handler := NewUserHandler(db, "other_key")
+	rr := httptest.NewRecorder()
+	handler.UpdateProfileAuth(rr, profileForm(t, map[string]string{"current_password": "guess", "new_password": "new-pass"}))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 Unauthorized, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func (h *UserHandler) GetSessionsAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, sessionID, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Sessions without a usable refresh token are dead even if never revoked
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT
+			s.id,
+			COALESCE(s.device, ''),
+			COALESCE(s.ip, ''),
+			COALESCE(s.user_agent, ''),
+			s.created_at,
+			s.last_seen_at
+		FROM sessions s
+		WHERE s.user_id = $1
+			AND s.revoked_at IS NULL
+			AND EXISTS (
+				SELECT 1 FROM refresh_tokens rt
+				WHERE rt.session_id = s.id AND rt.used_at IS NULL AND rt.expires_at > NOW()
+			)
+		ORDER BY s.last_seen_at DESC`, userID)
+	if err != nil {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.Device, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
+			http.Error(w, "Scan error", http.StatusInternalServerError)
+			return
+		}
+		s.Current = s.ID == sessionID
+		sessions = append(sessions, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (h *UserHandler) RevokeSessionAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/users/sessions/")
+	if id == "" {
+		http.Error(w, "Missing session ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(),
+		"UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		id, userID,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	count, _ := res.RowsAffected()
+	if count == 0 {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Session revoked"))
+}
+
+func (h *UserHandler) RevokeOtherSessionsAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, sessionID, err := h.parseSession(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	_, err = h.DB.ExecContext(r.Context(),
+		"UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL",
+		userID, sessionID,
+	)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Other sessions revoked"))
+}
+
+// parseSession returns the user and the session the request token belongs to
+func (h *UserHandler) parseSession(r *http.Request) (string, string, error) {
+	claims, err := utils.ParseClaims(r, h.SecretKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	userID, _ := claims["user_id"].(string)
+	sessionID, _ := claims["sid"].(string)
+	if userID == "" || sessionID == "" {
+		return "", "", fmt.Errorf("Invalid token")
+	}
+
+	return userID, sessionID, nil
+}
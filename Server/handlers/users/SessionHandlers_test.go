@@ -0,0 +1,102 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestGetSessions_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "device", "ip", "user_agent", "created_at", "last_seen_at"}).
+		AddRow("sess-1", "Firefox on Linux", "192.0.2.1", "Mozilla/5.0", "2025-01-01T00:00:00Z", "2025-01-02T00:00:00Z").
+		AddRow("sess-2", "Safari on iPhone", "198.51.100.4", "Mozilla/5.0", "2025-01-01T00:00:00Z", "2025-01-01T12:00:00Z")
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM sessions s")).WithArgs("5").WillReturnRows(rows)
+
+	token, _ := utils.GenerateMockSessionJWT("5", "sess-2", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.GetSessionsAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	var got []Session
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Error decoding response %v", err)
+	}
+
+	if len(got) != 2 || got[0].Current || !got[1].Current {
+		t.Errorf("Expected only sess-2 to be marked current, got %v", got)
+	}
+}
+
+func TestRevokeSession_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2")).
+		WithArgs("someone-elses", "5").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	token, _ := utils.GenerateMockSessionJWT("5", "sess-2", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/users/sessions/someone-elses", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.RevokeSessionAuth(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 Not Found, got %d", rr.Code)
+	}
+}
+
+func TestRevokeOtherSessions_KeepsCurrent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2")).
+		WithArgs("5", "sess-2").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	token, _ := utils.GenerateMockSessionJWT("5", "sess-2", "other_key")
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/users/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+
+	handler.RevokeOtherSessionsAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
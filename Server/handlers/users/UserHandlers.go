@@ -1,13 +1,17 @@
 package users
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 
-	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/assets"
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/handlers/recipes"
+	"github.com/Zheng5005/BiteBox/imaging"
+	"github.com/Zheng5005/BiteBox/logging"
 	"github.com/Zheng5005/BiteBox/utils"
 )
 
@@ -23,16 +27,17 @@ func (h *UserHandler) GetRecipesAuth (w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := h.DB.Query(`
+	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT 
 			r.id, 
 			r.name_recipe, 
 			r.description, 
 			r.meal_type_id, 
 			COALESCE(r.img_url, ''),
+			COALESCE(r.img_card_url, r.img_url, ''),
 			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg 
 		FROM recipes r 
-		LEFT JOIN comments c ON r.id = c.recipe_id 
+		LEFT JOIN comments c ON r.id = c.recipe_id AND c.is_hidden = false 
 		WHERE r.user_id = $1
 		GROUP BY r.id`, userID)
 	if err != nil {
@@ -44,13 +49,13 @@ func (h *UserHandler) GetRecipesAuth (w http.ResponseWriter, r *http.Request) {
 	var recipes []RecipesMainPage
 
 	for rows.Next() {
-		var r RecipesMainPage
-		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.MealTypeID, &r.ImgURL, &r.Rating); err != nil {
-			log.Println(err)
+		var recipe RecipesMainPage
+		if err := rows.Scan(&recipe.ID, &recipe.Name, &recipe.Description, &recipe.MealTypeID, &recipe.ImgURL, &recipe.ImgCardURL, &recipe.Rating); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
-		recipes = append(recipes, r)
+		recipes = append(recipes, recipe)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -81,9 +86,9 @@ func (h *UserHandler) DeActivateRecipeAuth(w http.ResponseWriter, r *http.Reques
 			WHERE id = $1 AND user_id = $2
 		`
 
-	_, err = h.DB.Exec(query, id, userID)
+	_, err = h.DB.ExecContext(r.Context(), query, id, userID)
 	if err != nil {
-		log.Panicln(err)
+		logging.FromContext(r.Context()).Error("Error deactivating recipe", "err", err)
 		http.Error(w, "Error deactivating recipe", http.StatusNotModified)
 		return
 	}
@@ -116,7 +121,7 @@ func (h *UserHandler) ActivateRecipeAuth(w http.ResponseWriter, r *http.Request)
 			WHERE id = $1 AND user_id = $2
 		`
 
-	_, err = h.DB.Exec(query, id, userID)
+	_, err = h.DB.ExecContext(r.Context(), query, id, userID)
 	if err != nil {
 		http.Error(w, "Error acativating recipe", http.StatusNotModified)
 		return
@@ -154,19 +159,23 @@ func (h *UserHandler) EditRecipeAuth(w http.ResponseWriter, r *http.Request)  {
 	mealType := r.FormValue("meal_type_id")
 	steps := r.FormValue("steps")
 
-	//Upload image to Cloudinary
-	file, fileHeader, err := r.FormFile("image")
-	var imageURL string
+	//Process and store the uploaded image
+	file, _, err := r.FormFile("image")
+	var imageURL, cardURL string
 
 	if err == nil {
 		defer file.Close()
 
-		// Assume you have a cloudinary uploader function
-		imageURL, err = lib.UploadToCloudinary(file, fileHeader.Filename)
-		if err != nil {
+		urls, err := imaging.Save(assets.WithOwner(r.Context(), userID), h.Images, file, imaging.Card, imaging.Detail)
+		if imaging.Rejected(err) {
+			http.Error(w, "Invalid image: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logging.FromContext(r.Context()).Error("Image upload error", "err", err)
 			http.Error(w, "Error uploading image", http.StatusInternalServerError)
 			return
 		}
+		imageURL, cardURL = urls[imaging.Detail.Name], urls[imaging.Card.Name]
 	} else if err != http.ErrMissingFile {
 		http.Error(w, "Error reading file", http.StatusBadRequest)
 		return
@@ -198,9 +207,9 @@ func (h *UserHandler) EditRecipeAuth(w http.ResponseWriter, r *http.Request)  {
     i++
   }
   if imageURL != "" {
-    updateFields = append(updateFields, fmt.Sprintf("img_url = $%d", i))
-    args = append(args, imageURL)
-    i++
+    updateFields = append(updateFields, fmt.Sprintf("img_url = $%d, img_detail_url = $%d, img_card_url = $%d", i, i, i+1))
+    args = append(args, imageURL, cardURL)
+    i += 2
   }
 
 	if len(updateFields) == 0 {
@@ -214,21 +223,77 @@ func (h *UserHandler) EditRecipeAuth(w http.ResponseWriter, r *http.Request)  {
     i, i+1,
   )
 
-	res, err := h.DB.Exec(query,args...)
+	// The UPDATE locks the recipe row for the cover change that follows
+	err = db.InTx(r.Context(), h.DB, func(tx db.Querier) error {
+		res, err := tx.ExecContext(r.Context(), query, args...)
+		if err != nil {
+			return err
+		}
+
+		if count, _ := res.RowsAffected(); count == 0 {
+			return sql.ErrNoRows
+		}
+
+		// The new image replaces the cover photo of the gallery
+		if imageURL != "" {
+			return recipes.ReplaceCover(r.Context(), tx, id, imageURL, cardURL)
+		}
+		return nil
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found or not owned by user", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Recipe Updated"))
+}
+
+func (h *UserHandler) SetUserRoleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/users/role/")
+	if id == "" {
+		http.Error(w, "Missing user ID", http.StatusBadRequest)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if !utils.ValidRole(input.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.ExecContext(r.Context(), "UPDATE users SET role = $1 WHERE id = $2", input.Role, id)
 	if err != nil {
-		log.Println("DB update error:", err)
-    http.Error(w, "Failed to update recipe", http.StatusInternalServerError)
-    return
+		logging.FromContext(r.Context()).Error("DB update error", "err", err)
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
 	}
 
 	count, _ := res.RowsAffected()
 	if count == 0 {
-		http.Error(w, "Recipe not found or not owned by user", http.StatusNotFound)
-    return
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Recipe Updated"))
+	w.Write([]byte("Role updated"))
 }
 
 func (h *UserHandler) GetRecipesByUser(w http.ResponseWriter, r *http.Request)  {
@@ -239,16 +304,17 @@ func (h *UserHandler) GetRecipesByUser(w http.ResponseWriter, r *http.Request)
 
 	user_name := r.URL.Query().Get("userName")
 
-	rows, err := h.DB.Query(`
+	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT 
 			r.id, 
 			r.name_recipe, 
 			r.description, 
 			r.meal_type_id, 
 			COALESCE(r.img_url, ''),
+			COALESCE(r.img_card_url, r.img_url, ''),
 			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg 
 		FROM recipes r 
-		LEFT JOIN comments c ON r.id = c.recipe_id
+		LEFT JOIN comments c ON r.id = c.recipe_id AND c.is_hidden = false
 		LEFT JOIN users u ON r.user_id = u.id
 		WHERE u.name = $1
 		GROUP BY r.id`, user_name)
@@ -261,13 +327,13 @@ func (h *UserHandler) GetRecipesByUser(w http.ResponseWriter, r *http.Request)
 	var recipes []RecipesMainPage
 
 	for rows.Next() {
-		var r RecipesMainPage
-		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.MealTypeID, &r.ImgURL, &r.Rating); err != nil {
-			log.Println(err)
+		var recipe RecipesMainPage
+		if err := rows.Scan(&recipe.ID, &recipe.Name, &recipe.Description, &recipe.MealTypeID, &recipe.ImgURL, &recipe.ImgCardURL, &recipe.Rating); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
-		recipes = append(recipes, r)
+		recipes = append(recipes, recipe)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -282,16 +348,17 @@ func (h *UserHandler) GetRecipesByGuestName(w http.ResponseWriter, r *http.Reque
 
 	guest_name := r.URL.Query().Get("guestName")
 
-	rows, err := h.DB.Query(`
+	rows, err := h.DB.QueryContext(r.Context(), `
 		SELECT 
 			r.id, 
 			r.name_recipe, 
 			r.description, 
 			r.meal_type_id, 
 			COALESCE(r.img_url, ''),
+			COALESCE(r.img_card_url, r.img_url, ''),
 			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg 
 		FROM recipes r 
-		LEFT JOIN comments c ON r.id = c.recipe_id 
+		LEFT JOIN comments c ON r.id = c.recipe_id AND c.is_hidden = false 
 		WHERE r.guest_name = $1
 		GROUP BY r.id`, guest_name)
 	if err != nil {
@@ -303,13 +370,13 @@ func (h *UserHandler) GetRecipesByGuestName(w http.ResponseWriter, r *http.Reque
 	var recipes []RecipesMainPage
 
 	for rows.Next() {
-		var r RecipesMainPage
-		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.MealTypeID, &r.ImgURL, &r.Rating); err != nil {
-			log.Println(err)
+		var recipe RecipesMainPage
+		if err := rows.Scan(&recipe.ID, &recipe.Name, &recipe.Description, &recipe.MealTypeID, &recipe.ImgURL, &recipe.ImgCardURL, &recipe.Rating); err != nil {
+			logging.FromContext(r.Context()).Error("Scan error", "err", err)
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
-		recipes = append(recipes, r)
+		recipes = append(recipes, recipe)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
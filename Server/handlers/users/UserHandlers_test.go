@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -20,9 +21,9 @@ func TestGetUserRecipes_Success(t *testing.T)  {
 	defer db.Close()
 
 	// expected rows
-	rows := sqlmock.NewRows([]string{"id", "name_recipe", "description", "meal_type_id", "img_url", "rating",}).
-		AddRow("1", "Carbonara", "Best pasta in Italy", "2", "", "5",).
-		AddRow("2", "Pupusas", "La mejor comida de El Salvador", "1", "", "5",)
+	rows := sqlmock.NewRows([]string{"id", "name_recipe", "description", "meal_type_id", "img_url", "img_card_url", "rating",}).
+		AddRow("1", "Carbonara", "Best pasta in Italy", "2", "", "", "5",).
+		AddRow("2", "Pupusas", "La mejor comida de El Salvador", "1", "", "", "5",)
 
 	mock.ExpectQuery(regexp.QuoteMeta(`
 		SELECT 
@@ -31,9 +32,10 @@ func TestGetUserRecipes_Success(t *testing.T)  {
 			r.description, 
 			r.meal_type_id, 
 			COALESCE(r.img_url, ''),
+			COALESCE(r.img_card_url, r.img_url, ''),
 			COALESCE(ROUND(CAST(AVG(c.rating) AS numeric), 2), 0) AS avg 
 		FROM recipes r 
-		LEFT JOIN comments c ON r.id = c.recipe_id
+		LEFT JOIN comments c ON r.id = c.recipe_id AND c.is_hidden = false
 		WHERE r.user_id = $1
 		GROUP BY r.id
 	`)).WithArgs("5").WillReturnRows(rows)
@@ -69,6 +71,52 @@ func TestGetUserRecipes_Success(t *testing.T)  {
 	}
 }
 
+func TestSetUserRole_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET role = $1 WHERE id = $2")).
+		WithArgs("moderator", "7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/users/role/7", strings.NewReader(`{"role": "moderator"}`))
+	rr := httptest.NewRecorder()
+
+	handler.SetUserRoleAuth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 OK, got %d", rr.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestSetUserRole_InvalidRole(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	handler := NewUserHandler(db, "other_key")
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/users/role/7", strings.NewReader(`{"role": "superuser"}`))
+	rr := httptest.NewRecorder()
+
+	handler.SetUserRoleAuth(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 Bad Request, got %d", rr.Code)
+	}
+}
+
 func TestEditRecipe_Success(t *testing.T)  {
 	
 }
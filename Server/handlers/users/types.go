@@ -1,6 +1,10 @@
 package users
 
-import "github.com/Zheng5005/BiteBox/db"
+import (
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/events"
+	"github.com/Zheng5005/BiteBox/lib"
+)
 
 type User struct {
 	ID   string `json:"id"`
@@ -8,7 +12,6 @@ type User struct {
 	Email string `json:"email"`
 	Password string `json:"password"`
 	URLPhoto string `json:"url_photo"`
-	GoogleID string `json:"google_id"`
 }
 
 type RecipesMainPage struct {
@@ -17,6 +20,7 @@ type RecipesMainPage struct {
 	Description string `json:"description"`
 	MealTypeID string `json:"meal_type_id"`
 	ImgURL string `json:"img_url"`
+	ImgCardURL string `json:"img_card_url"`
 
 	Rating string `json:"rating"`
 }
@@ -24,8 +28,57 @@ type RecipesMainPage struct {
 type UserHandler struct {
 	DB db.DBExecutor
 	SecretKey string
+	// ExportDir is where the data export archives are written
+	ExportDir string
+	// Events is told about new follows, may be nil
+	Events *events.Dispatcher
+	// Images stores uploaded recipe and profile photos
+	Images lib.ImageStore
 }
 
 func NewUserHandler(db db.DBExecutor, secret string) *UserHandler {
-	return &UserHandler{DB: db, SecretKey: secret}
+	return &UserHandler{DB: db, SecretKey: secret, ExportDir: "exports"}
+}
+
+type Session struct {
+	ID         string `json:"id"`
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+type Identity struct {
+	Provider  string `json:"provider"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+type Profile struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	URLPhoto       string `json:"url_photo"`
+	Bio            string `json:"bio"`
+	RecipeCount    int    `json:"recipe_count"`
+	AverageRating  string `json:"average_rating"`
+	RatingCount    int    `json:"rating_count"`
+	FollowerCount  int    `json:"follower_count"`
+	FollowingCount int    `json:"following_count"`
+}
+
+type DataExport struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	CreatedAt   string `json:"created_at"`
+	CompletedAt string `json:"completed_at,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+}
+
+type FollowUser struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	URLPhoto   string `json:"url_photo"`
+	FollowedAt string `json:"followed_at"`
 }
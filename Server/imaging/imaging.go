@@ -0,0 +1,142 @@
+// Package imaging checks uploaded images and renders the sizes the client shows.
+// Every variant is re-encoded from the decoded pixels, so no metadata (EXIF, GPS, ...)
+// from the upload survives.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxUploadBytes is the largest file accepted
+	MaxUploadBytes = 10 << 20
+	// MaxPixels stops decompression bombs, small files that decode to huge images
+	MaxPixels = 40_000_000
+	MaxSide   = 12_000
+	// JPEGQuality is used for every variant
+	JPEGQuality = 85
+)
+
+var (
+	ErrNotImage = errors.New("file is not a supported image")
+	ErrTooLarge = errors.New("image is too large")
+)
+
+// Formats accepted, by sniffed content type
+var formats = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Variant is one rendered size. Crop fills the box exactly, otherwise the image fits inside it.
+// Images are never scaled up.
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+	Crop   bool
+}
+
+var (
+	Card   = Variant{Name: "card", Width: 640, Height: 480, Crop: true}
+	Detail = Variant{Name: "detail", Width: 1600, Height: 1600}
+	Avatar = Variant{Name: "avatar", Width: 256, Height: 256, Crop: true}
+)
+
+// Process validates the upload and returns each variant as a JPEG, by variant name
+func Process(r io.Reader, variants ...Variant) (map[string][]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxUploadBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MaxUploadBytes {
+		return nil, ErrTooLarge
+	}
+
+	// The declared content type and extension are the client's word, the bytes are not
+	if !formats[http.DetectContentType(data)] {
+		return nil, ErrNotImage
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrNotImage
+	}
+
+	if cfg.Width > MaxSide || cfg.Height > MaxSide || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+
+	img = orient(img, exifOrientation(data))
+
+	out := make(map[string][]byte, len(variants))
+	for _, v := range variants {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, render(img, v), &jpeg.Options{Quality: JPEGQuality}); err != nil {
+			return nil, err
+		}
+		out[v.Name] = buf.Bytes()
+	}
+
+	return out, nil
+}
+
+// render scales the image for the variant over a white background, JPEG has no transparency
+func render(img image.Image, v Variant) image.Image {
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+
+	if v.Crop {
+		// Cut the centered part of the image with the variant's aspect ratio
+		if w*v.Height > h*v.Width {
+			cw := h * v.Width / v.Height
+			src.Min.X += (w - cw) / 2
+			src.Max.X = src.Min.X + cw
+		} else {
+			ch := w * v.Height / v.Width
+			src.Min.Y += (h - ch) / 2
+			src.Max.Y = src.Min.Y + ch
+		}
+		w, h = min(src.Dx(), v.Width), min(src.Dy(), v.Height)
+	} else if w > v.Width || h > v.Height {
+		if w*v.Height > h*v.Width {
+			w, h = v.Width, max(1, h*v.Width/w)
+		} else {
+			w, h = max(1, w*v.Height/h), v.Height
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, w), max(1, h)))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+	return dst
+}
+
+// Rejected reports whether the upload was refused for its content, a client error
+func Rejected(err error) bool {
+	return errors.Is(err, ErrNotImage) || errors.Is(err, ErrTooLarge)
+}
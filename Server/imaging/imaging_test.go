@@ -0,0 +1,98 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decodedSize(t *testing.T, data []byte) (int, int) {
+	t.Helper()
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Variant is not a JPEG: %v", err)
+	}
+	return cfg.Width, cfg.Height
+}
+
+func TestProcess_Variants(t *testing.T) {
+	out, err := Process(bytes.NewReader(encodePNG(t, 1000, 500)), Card, Detail, Avatar)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	cases := map[string][2]int{
+		"card":   {640, 480},
+		"detail": {1000, 500}, // fits already, never scaled up
+		"avatar": {256, 256},
+	}
+	for name, want := range cases {
+		if w, h := decodedSize(t, out[name]); w != want[0] || h != want[1] {
+			t.Errorf("%s: expected %dx%d, got %dx%d", name, want[0], want[1], w, h)
+		}
+	}
+}
+
+func TestProcess_RejectsNonImages(t *testing.T) {
+	_, err := Process(strings.NewReader("<html><script>alert(1)</script></html>"), Card)
+	if !errors.Is(err, ErrNotImage) {
+		t.Errorf("Expected ErrNotImage, got %v", err)
+	}
+}
+
+func TestProcess_RejectsDecompressionBombs(t *testing.T) {
+	// A tiny PNG whose header claims 20000x20000 pixels
+	data := encodePNG(t, 1, 1)
+	binary.BigEndian.PutUint32(data[16:], 20000)
+	binary.BigEndian.PutUint32(data[20:], 20000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err := Process(bytes.NewReader(data), Card)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestProcess_AppliesEXIFOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20)), nil)
+	data := buf.Bytes()
+
+	// Big endian TIFF with one IFD entry: orientation (0x0112), SHORT, 1 value, 6 (rotate 90)
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 6, 0, 0, 0, 0, 0, 0}
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(app1)+2))
+	segment = append(segment, app1...)
+
+	withExif := append(append([]byte{0xFF, 0xD8}, segment...), data[2:]...)
+
+	out, err := Process(bytes.NewReader(withExif), Detail)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	if w, h := decodedSize(t, out["detail"]); w != 20 || h != 40 {
+		t.Errorf("Expected the image turned upright to 20x40, got %dx%d", w, h)
+	}
+
+	// The re-encoded variant carries no EXIF
+	if bytes.Contains(out["detail"], []byte("Exif")) {
+		t.Error("Expected metadata to be stripped")
+	}
+}
@@ -0,0 +1,39 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/metrics"
+)
+
+// Save processes the upload and stores every variant, returning their URLs by variant name
+func Save(ctx context.Context, store lib.ImageStore, file io.Reader, variants ...Variant) (map[string]string, error) {
+	start := time.Now()
+
+	rendered, err := Process(file, variants...)
+	if err != nil {
+		reason := "error"
+		if Rejected(err) {
+			reason = "rejected"
+		}
+		metrics.ImageUploadFailures.WithLabelValues(reason).Inc()
+		return nil, err
+	}
+
+	urls := make(map[string]string, len(variants))
+	for _, v := range variants {
+		url, err := store.Save(ctx, bytes.NewReader(rendered[v.Name]), v.Name+".jpg")
+		if err != nil {
+			metrics.ImageUploadFailures.WithLabelValues("storage").Inc()
+			return nil, err
+		}
+		urls[v.Name] = url
+	}
+
+	metrics.ImageUploadDuration.Observe(time.Since(start).Seconds())
+	return urls, nil
+}
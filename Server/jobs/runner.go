@@ -0,0 +1,72 @@
+// Package jobs runs background work on a fixed interval next to the HTTP server.
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Job is one unit of periodic work. Runs of the same job never overlap.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+type Runner struct {
+	jobs []Job
+	wg   sync.WaitGroup
+}
+
+func NewRunner() *Runner {
+	return &Runner{}
+}
+
+func (r *Runner) Add(job Job) {
+	r.jobs = append(r.jobs, job)
+}
+
+// Start runs every job once right away and then on its interval, until ctx is done
+func (r *Runner) Start(ctx context.Context) {
+	for _, job := range r.jobs {
+		r.wg.Add(1)
+		go func(job Job) {
+			defer r.wg.Done()
+
+			ticker := time.NewTicker(job.Interval)
+			defer ticker.Stop()
+
+			for {
+				r.run(ctx, job)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(job)
+	}
+}
+
+// run gives each run its own trace, the job's queries are recorded under it
+func (r *Runner) run(ctx context.Context, job Job) {
+	ctx, span := tracing.Tracer().Start(ctx, "job "+job.Name, trace.WithNewRoot())
+	defer span.End()
+
+	err := job.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		slog.Error("Job failed", "job", job.Name, "err", err)
+		tracing.RecordError(span, err)
+	}
+}
+
+// Wait blocks until every job has returned, after the context given to Start is done
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
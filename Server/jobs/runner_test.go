@@ -0,0 +1,34 @@
+package jobs
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunner_RunsUntilCancelled(t *testing.T) {
+	var runs atomic.Int32
+
+	runner := NewRunner()
+	runner.Add(Job{Name: "count", Interval: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner.Start(ctx)
+	time.Sleep(55 * time.Millisecond)
+	cancel()
+	runner.Wait()
+
+	stopped := runs.Load()
+	if stopped < 2 {
+		t.Fatalf("Expected the job to run several times, ran %d", stopped)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if runs.Load() != stopped {
+		t.Errorf("Expected no runs after Wait returned")
+	}
+}
@@ -2,34 +2,86 @@ package lib
 
 import (
 	"context"
-	"log"
-	"mime/multipart"
-	"os"
+	"fmt"
+	"io"
+	"path"
+	"strings"
 
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
-	"github.com/joho/godotenv"
 )
 
-func UploadToCloudinary(file multipart.File, filename string) (string, error)  {
-	errENV := godotenv.Load()
-	if errENV != nil {
-		log.Println("No .env file available")
-	}
+// CloudinaryStore uploads images to Cloudinary, the client is built once and reused
+type CloudinaryStore struct {
+	cld *cloudinary.Cloudinary
+}
 
-	cld, _ := cloudinary.NewFromParams(getEnv("CLOUDINARY_CLOUD", "your-cloud-name"), getEnv("CLOUDINARY_API_KEY", "your-api-key"), getEnv("CLOUDINARY_API_SECRET", "your-api-secret"))
+func NewCloudinaryStore(cloud, apiKey, apiSecret string) (*CloudinaryStore, error) {
+	cld, err := cloudinary.NewFromParams(cloud, apiKey, apiSecret)
+	if err != nil {
+		return nil, fmt.Errorf("cloudinary: %w", err)
+	}
+	return &CloudinaryStore{cld: cld}, nil
+}
 
-	uploadResult, err := cld.Upload.Upload(context.Background(), file, uploader.UploadParams{})
+func (s *CloudinaryStore) Save(ctx context.Context, file io.Reader, filename string) (string, error) {
+	uploadResult, err := s.cld.Upload.Upload(ctx, file, uploader.UploadParams{})
 	if err != nil {
 		return "", err
 	}
 
+	if uploadResult.Error.Message != "" {
+		return "", fmt.Errorf("cloudinary: %s", uploadResult.Error.Message)
+	}
+
 	return uploadResult.SecureURL, nil
 }
 
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// Delete destroys the image by its public ID, read back from a delivery URL such as
+// https://res.cloudinary.com/<cloud>/image/upload/v1712345678/<public_id>.jpg
+func (s *CloudinaryStore) Delete(ctx context.Context, url string) error {
+	_, rest, ok := strings.Cut(url, "/image/upload/")
+	if !ok || !strings.HasPrefix(url, "https://res.cloudinary.com/") {
+		return ErrNotStored
+	}
+
+	if version, after, ok := strings.Cut(rest, "/"); ok && isVersion(version) {
+		rest = after
+	}
+
+	result, err := s.cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: strings.TrimSuffix(rest, path.Ext(rest))})
+	if err != nil {
+		return err
+	}
+
+	// "not found" means it is already gone
+	if result.Error.Message != "" {
+		return fmt.Errorf("cloudinary: %s", result.Error.Message)
+	}
+
+	return nil
+}
+
+func (s *CloudinaryStore) Ping(ctx context.Context) error {
+	result, err := s.cld.Admin.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	if result.Error.Message != "" {
+		return fmt.Errorf("cloudinary: %s", result.Error.Message)
+	}
+	return nil
+}
+
+// isVersion matches the v<digits> path segment Cloudinary adds to delivery URLs
+func isVersion(segment string) bool {
+	if len(segment) < 2 || segment[0] != 'v' {
+		return false
+	}
+	for _, c := range segment[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
 	}
-	return fallback
+	return true
 }
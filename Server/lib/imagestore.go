@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/Zheng5005/BiteBox/config"
+)
+
+// ImageStore keeps uploaded images and returns the public URL they are served from.
+// Delete takes a URL returned by Save, deleting an image that is already gone is not an error.
+type ImageStore interface {
+	Save(ctx context.Context, file io.Reader, filename string) (string, error)
+	Delete(ctx context.Context, url string) error
+}
+
+// Pinger is implemented by stores that can check their backend is reachable, for readiness probes
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ErrNotStored is returned by Delete for URLs the store did not hand out
+var ErrNotStored = errors.New("image was not stored here")
+
+// NewImageStore picks the backend from cfg.Backend (cloudinary, local or s3).
+// Without it Cloudinary is used when a Cloudinary cloud is set, local files otherwise.
+func NewImageStore(cfg config.Images) (ImageStore, error) {
+	switch backend := cfg.Store(); backend {
+	case "cloudinary":
+		return NewCloudinaryStore(cfg.Cloudinary.Cloud, cfg.Cloudinary.APIKey, cfg.Cloudinary.APISecret)
+	case "local":
+		return NewLocalStore(cfg.MediaDir, cfg.MediaBaseURL)
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			UseSSL:    cfg.S3.UseSSL,
+			PublicURL: cfg.S3.PublicURL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown image store %q, use cloudinary, local or s3", backend)
+	}
+}
+
+// objectName gives every upload a unique name, keeping only the extension of the client's filename
+func objectName(filename string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b) + strings.ToLower(filepath.Ext(filename))
+}
+
+// storedName is the inverse of Save for stores serving objectName under baseURL
+func storedName(url, baseURL string) (string, error) {
+	name, ok := strings.CutPrefix(url, baseURL+"/")
+	if !ok || name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return "", ErrNotStored
+	}
+	return name, nil
+}
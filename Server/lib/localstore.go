@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore writes images to a directory served by the API under /media/,
+// for development and single server deployments
+type LocalStore struct {
+	Dir string
+	// BaseURL is where Dir is served, the returned URLs are BaseURL/<name>
+	BaseURL string
+}
+
+func NewLocalStore(dir, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *LocalStore) Save(ctx context.Context, file io.Reader, filename string) (string, error) {
+	name := objectName(filename)
+	path := filepath.Join(s.Dir, name)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(f, file); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return s.BaseURL + "/" + name, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, url string) error {
+	name, err := storedName(url, s.BaseURL)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(s.Dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Ping checks the directory is still there
+func (s *LocalStore) Ping(ctx context.Context) error {
+	info, err := os.Stat(s.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.Dir)
+	}
+	return nil
+}
+
+// Handler serves the stored files, mount it with http.StripPrefix
+func (s *LocalStore) Handler() http.Handler {
+	files := http.FileServer(http.Dir(s.Dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No directory listings
+		if strings.HasSuffix(r.URL.Path, "/") || r.URL.Path == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore_SaveAndServe(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "http://localhost:8080/media/")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	url, err := store.Save(context.Background(), strings.NewReader("fake image"), "Photo.JPG")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	name, ok := strings.CutPrefix(url, "http://localhost:8080/media/")
+	if !ok || !strings.HasSuffix(name, ".jpg") {
+		t.Fatalf("unexpected URL %q", url)
+	}
+
+	handler := http.StripPrefix("/media/", store.Handler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/media/"+name, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "fake image" {
+		t.Errorf("expected the stored file, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// The directory itself is not listed
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/media/", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for the directory, got %d", rr.Code)
+	}
+}
+
+func TestLocalStore_Delete(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	url, err := store.Save(context.Background(), strings.NewReader("fake image"), "photo.jpg")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if err := store.Delete(context.Background(), url); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	// Deleting again is fine, the image is already gone
+	if err := store.Delete(context.Background(), url); err != nil {
+		t.Errorf("Expected a second delete to succeed, got %v", err)
+	}
+
+	// URLs outside the store are refused, even when they point into it
+	for _, foreign := range []string{"https://example.com/photo.jpg", "http://localhost:8080/media/../secret"} {
+		if err := store.Delete(context.Background(), foreign); err != ErrNotStored {
+			t.Errorf("Delete(%q): expected ErrNotStored, got %v", foreign, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/config"
+)
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer delivers mail through an SMTP relay
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg))
+}
+
+// LogMailer writes every email to Out instead of sending it, for local development and tests
+type LogMailer struct {
+	mu  sync.Mutex
+	Out io.Writer
+}
+
+func NewLogMailer(out io.Writer) *LogMailer {
+	return &LogMailer{Out: out}
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.Out, "To: %s\nSubject: %s\n\n%s\n-----\n", to, subject, body)
+	return err
+}
+
+// NewMailer uses SMTP when an SMTP host is set, otherwise mail goes to the log file or stdout
+func NewMailer(cfg config.Mail) (Mailer, error) {
+	if cfg.SMTPHost != "" {
+		return &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}, nil
+	}
+
+	if cfg.LogFile == "" {
+		return NewLogMailer(os.Stdout), nil
+	}
+
+	f, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogMailer(f), nil
+}
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config points at any S3 compatible service (AWS, MinIO, R2, ...)
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// PublicURL is where the bucket's objects are readable, by default the endpoint's bucket path
+	PublicURL string
+}
+
+// S3Store uploads images to a bucket that is publicly readable
+type S3Store struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3: S3_ENDPOINT and S3_BUCKET are required")
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+
+	publicURL := cfg.PublicURL
+	if publicURL == "" {
+		scheme := "http"
+		if cfg.UseSSL {
+			scheme = "https"
+		}
+		publicURL = fmt.Sprintf("%s://%s/%s", scheme, cfg.Endpoint, cfg.Bucket)
+	}
+
+	return &S3Store{client: client, bucket: cfg.Bucket, publicURL: strings.TrimSuffix(publicURL, "/")}, nil
+}
+
+func (s *S3Store) Save(ctx context.Context, file io.Reader, filename string) (string, error) {
+	name := objectName(filename)
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// Size -1 makes the client stream the upload in parts
+	_, err := s.client.PutObject(ctx, s.bucket, name, file, -1, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return "", fmt.Errorf("s3: %w", err)
+	}
+
+	return s.publicURL + "/" + name, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, url string) error {
+	name, err := storedName(url, s.publicURL)
+	if err != nil {
+		return err
+	}
+
+	// Removing a missing object succeeds
+	if err := s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("s3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Store) Ping(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return fmt.Errorf("s3: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("s3: bucket %s does not exist", s.bucket)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Package logging sets up the structured logger and carries a logger per request in the context,
+// tagged with the request's ID, so every line a request writes can be found together.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// New returns a JSON logger, or a text logger for reading in a terminal, at the given level
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level %q: use debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("log format %q: use json or text", format)
+	}
+}
+
+type loggerKey struct{}
+
+type userKey struct{}
+
+// WithLogger returns a context carrying the logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the request's logger, or the default one outside of requests
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// requestInfo is filled in as the request is handled, by authentication and tracing
+type requestInfo struct {
+	mu      sync.Mutex
+	userID  string
+	traceID string
+}
+
+// WithUserSlot prepares the context for SetUserID and SetTraceID, UserID and TraceID read
+// them back after the request
+func WithUserSlot(ctx context.Context) context.Context {
+	return context.WithValue(ctx, userKey{}, &requestInfo{})
+}
+
+// SetUserID records the authenticated user for the access log
+func SetUserID(ctx context.Context, userID string) {
+	if info, ok := ctx.Value(userKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.userID = userID
+		info.mu.Unlock()
+	}
+}
+
+func UserID(ctx context.Context) string {
+	if info, ok := ctx.Value(userKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.userID
+	}
+	return ""
+}
+
+// SetTraceID records the request's trace for the access log
+func SetTraceID(ctx context.Context, traceID string) {
+	if info, ok := ctx.Value(userKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.traceID = traceID
+		info.mu.Unlock()
+	}
+}
+
+func TraceID(ctx context.Context) string {
+	if info, ok := ctx.Value(userKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		defer info.mu.Unlock()
+		return info.traceID
+	}
+	return ""
+}
@@ -1,42 +1,161 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Zheng5005/BiteBox/assets"
+	"github.com/Zheng5005/BiteBox/config"
 	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/events"
 	"github.com/Zheng5005/BiteBox/handlers/auth"
 	"github.com/Zheng5005/BiteBox/handlers/comments"
+	"github.com/Zheng5005/BiteBox/handlers/health"
 	"github.com/Zheng5005/BiteBox/handlers/meals"
+	"github.com/Zheng5005/BiteBox/handlers/moderation"
+	"github.com/Zheng5005/BiteBox/handlers/notifications"
 	"github.com/Zheng5005/BiteBox/handlers/recipes"
+	"github.com/Zheng5005/BiteBox/handlers/stream"
 	"github.com/Zheng5005/BiteBox/handlers/users"
+	"github.com/Zheng5005/BiteBox/jobs"
+	"github.com/Zheng5005/BiteBox/lib"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/metrics"
 	"github.com/Zheng5005/BiteBox/middlewares"
+	"github.com/Zheng5005/BiteBox/realtime"
+	"github.com/Zheng5005/BiteBox/tracing"
+	"github.com/Zheng5005/BiteBox/utils"
 )
 
 func main() {
-	db.InitDB()
-	secret := os.Getenv("SECRET_KEY")
-	if secret == "" {
-		secret = "other_key"
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
 	}
 
-	commentHandler := comments.NewCommentHandler(db.DB, secret)
-	recipesHandler := recipes.NewRecipesHandler(db.DB, secret)
-	authHandler := auth.NewAuthHandler(db.DB, secret)
-	userHandler := users.NewUserHandler(db.DB, secret)
+	// Everything logged from here on, including through the log package, is structured
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	for _, warning := range cfg.Warnings {
+		slog.Warn(warning)
+	}
+	slog.Info("Starting", "env", cfg.Env, "config", cfg.Redacted())
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	db.InitDB(cfg.Database)
+	metrics.RegisterDB(db.DB, cfg.Database.Name)
+	tracedDB := db.Traced(db.DB)
+	secret := cfg.SecretKey
+
+	mailer, err := lib.NewMailer(cfg.Mail)
+	if err != nil {
+		fatal("Failed to set up mailer", err)
+	}
+
+	images, err := lib.NewImageStore(cfg.Images)
+	if err != nil {
+		fatal("Failed to set up image storage", err)
+	}
+	trackedImages := assets.NewStore(tracedDB, tracing.NewStore(images))
+
+	dispatcher := events.NewDispatcher()
+
+	commentHandler := comments.NewCommentHandler(tracedDB, secret)
+	commentHandler.Events = dispatcher
+	recipesHandler := recipes.NewRecipesHandler(tracedDB, secret)
+	recipesHandler.Images = trackedImages
+	authHandler := auth.NewAuthHandler(tracedDB, secret, mailer)
+	authHandler.Images = trackedImages
+	authHandler.AppURL = cfg.AppURL
+	google, err := lib.NewGoogleProvider(context.Background(), cfg.Google)
+	if err != nil {
+		slog.Warn("Google login disabled", "err", err)
+	} else if google != nil {
+		authHandler.Providers[google.Name] = google
+	}
+	userHandler := users.NewUserHandler(tracedDB, secret)
+	userHandler.ExportDir = cfg.ExportDir
+	userHandler.Events = dispatcher
+	userHandler.Images = trackedImages
+	moderationHandler := moderation.NewModerationHandler(tracedDB, secret)
+	moderationHandler.Events = dispatcher
+	notificationHandler := notifications.NewNotificationHandler(tracedDB, secret)
+	notificationHandler.Events = dispatcher
+	notificationHandler.Subscribe(dispatcher)
+	hub := realtime.NewHub()
+	streamHandler := stream.NewStreamHandler(tracedDB, secret, hub)
+	streamHandler.Subscribe(dispatcher)
+	authenticator := middleware.NewAuthenticator(tracedDB, secret)
+	limiter := middleware.NewRateLimiter(middleware.NewMemoryStore(), secret)
 
 	mux := http.NewServeMux()
 
+	// Health routes, for probes and deploys
+	healthHandler := health.NewHealthHandler(db.DB, images)
+	mux.HandleFunc("GET /healthz", healthHandler.Healthz)
+	mux.HandleFunc("GET /readyz", healthHandler.Readyz)
+	mux.HandleFunc("GET /version", healthHandler.Version)
+	mux.Handle("GET /metrics", metrics.Handler(cfg.MetricsToken))
+
 	// Auth routes
-	mux.HandleFunc("/api/auth/signup", authHandler.SignUpHandler)
-	mux.HandleFunc("/api/auth/login", authHandler.LoginHandler)
+	mux.HandleFunc("/api/auth/signup", limiter.Limit("signup", middleware.Limit{Burst: 5, Per: time.Hour}, authHandler.SignUpHandler))
+	mux.HandleFunc("/api/auth/login", limiter.Limit("login", middleware.Limit{Burst: 10, Per: time.Minute}, authHandler.LoginHandler))
+	mux.HandleFunc("/api/auth/login/2fa", limiter.Limit("login-2fa", middleware.Limit{Burst: 5, Per: time.Minute}, authHandler.LoginTwoFactorHandler))
+	mux.HandleFunc("/api/auth/refresh", limiter.Limit("refresh", middleware.Limit{Burst: 30, Per: time.Minute}, authHandler.RefreshHandler))
+	mux.HandleFunc("/api/auth/logout", authHandler.LogoutHandler)
+	mux.HandleFunc("/api/auth/password/forgot", limiter.Limit("password-forgot", middleware.Limit{Burst: 5, Per: time.Hour}, authHandler.ForgotPasswordHandler))
+	mux.HandleFunc("/api/auth/password/reset", limiter.Limit("password-reset", middleware.Limit{Burst: 10, Per: time.Hour}, authHandler.ResetPasswordHandler))
+	mux.HandleFunc("/api/auth/verify/request", limiter.Limit("verify-request", middleware.Limit{Burst: 3, Per: time.Hour}, authenticator.JWTMiddleware(authHandler.RequestVerificationHandler)))
+	mux.HandleFunc("/api/auth/verify/confirm", authHandler.ConfirmVerificationHandler)
+	mux.HandleFunc("/api/auth/2fa/enroll", authenticator.JWTMiddleware(authHandler.EnrollTOTPHandler))
+	mux.HandleFunc("/api/auth/2fa/verify", authenticator.JWTMiddleware(authHandler.VerifyTOTPHandler))
+	mux.HandleFunc("/api/auth/2fa/disable", authenticator.JWTMiddleware(authHandler.DisableTOTPHandler))
+	mux.HandleFunc("GET /api/auth/{provider}/start", authHandler.OAuthStartHandler)
+	mux.HandleFunc("GET /api/auth/{provider}/callback", authHandler.OAuthCallbackHandler)
 
 	// Users routes
-	mux.HandleFunc("/api/users", middleware.JWTMiddleware(userHandler.GetRecipesAuth))
-	mux.HandleFunc("PATCH /api/users/edit/", middleware.JWTMiddleware(userHandler.EditRecipeAuth))
-	mux.HandleFunc("PATCH /api/users/deactivate/", middleware.JWTMiddleware(userHandler.DeActivateRecipeAuth))
-	mux.HandleFunc("PATCH /api/users/activate/", middleware.JWTMiddleware(userHandler.ActivateRecipeAuth))
+	mux.HandleFunc("/api/users", authenticator.JWTMiddleware(userHandler.GetRecipesAuth))
+	mux.HandleFunc("PATCH /api/users/edit/", authenticator.JWTMiddleware(userHandler.EditRecipeAuth))
+	mux.HandleFunc("PATCH /api/users/deactivate/", authenticator.JWTMiddleware(userHandler.DeActivateRecipeAuth))
+	mux.HandleFunc("PATCH /api/users/activate/", authenticator.JWTMiddleware(userHandler.ActivateRecipeAuth))
+	mux.HandleFunc("PATCH /api/users/role/", authenticator.RoleMiddleware(utils.RoleAdmin, userHandler.SetUserRoleAuth))
+
+	mux.HandleFunc("GET /api/users/sessions", authenticator.JWTMiddleware(userHandler.GetSessionsAuth))
+	mux.HandleFunc("DELETE /api/users/sessions", authenticator.JWTMiddleware(userHandler.RevokeOtherSessionsAuth))
+	mux.HandleFunc("DELETE /api/users/sessions/", authenticator.JWTMiddleware(userHandler.RevokeSessionAuth))
+
+	mux.HandleFunc("GET /api/users/identities", authenticator.JWTMiddleware(userHandler.GetIdentitiesAuth))
+	mux.HandleFunc("POST /api/users/identities/{provider}", authenticator.JWTMiddleware(authHandler.LinkIdentityHandler))
+	mux.HandleFunc("POST /api/users/identities/{provider}/confirm", authenticator.JWTMiddleware(authHandler.ConfirmLinkHandler))
+	mux.HandleFunc("DELETE /api/users/identities/{provider}", authenticator.JWTMiddleware(userHandler.UnlinkIdentityAuth))
+
+	mux.HandleFunc("PATCH /api/users/me", limiter.Limit("profile", middleware.Limit{Burst: 10, Per: time.Minute}, authenticator.JWTMiddleware(userHandler.UpdateProfileAuth)))
+	mux.HandleFunc("GET /api/users/{id}", userHandler.GetProfile)
+
+	mux.HandleFunc("POST /api/users/me/export", limiter.Limit("export", middleware.Limit{Burst: 3, Per: 24 * time.Hour}, authenticator.JWTMiddleware(userHandler.RequestExportAuth)))
+	mux.HandleFunc("GET /api/users/me/exports", authenticator.JWTMiddleware(userHandler.GetExportsAuth))
+	mux.HandleFunc("GET /api/users/me/exports/{id}", authenticator.JWTMiddleware(userHandler.DownloadExportAuth))
+	mux.HandleFunc("DELETE /api/users/me", authenticator.JWTMiddleware(userHandler.DeleteAccountAuth))
+	mux.HandleFunc("POST /api/users/me/deletion/cancel", authenticator.JWTMiddleware(userHandler.CancelDeletionAuth))
+
+	mux.HandleFunc("POST /api/users/follow/{id}", authenticator.JWTMiddleware(userHandler.FollowAuth))
+	mux.HandleFunc("DELETE /api/users/follow/{id}", authenticator.JWTMiddleware(userHandler.UnfollowAuth))
+	mux.HandleFunc("GET /api/users/{id}/followers", userHandler.GetFollowers)
+	mux.HandleFunc("GET /api/users/{id}/following", userHandler.GetFollowing)
 
 	mux.HandleFunc("GET /api/users/ByUser", userHandler.GetRecipesByUser)
 	mux.HandleFunc("GET /api/users/ByGuest", userHandler.GetRecipesByGuestName)
@@ -44,19 +163,120 @@ func main() {
 	// Recipes routes
 	mux.HandleFunc("/api/recipes", recipesHandler.RecipeHandler)
 	mux.HandleFunc("/api/recipes/", recipesHandler.RecipeONEHandler)
-	mux.HandleFunc("/api/recipes/post", recipesHandler.PostRecipe)
+	mux.HandleFunc("GET /api/feed/following", authenticator.JWTMiddleware(recipesHandler.FollowingFeedAuth))
+	mux.HandleFunc("POST /api/recipes/{id}/images", limiter.Limit("recipe-images", middleware.Limit{Burst: 30, Per: time.Hour}, authenticator.JWTMiddleware(recipesHandler.AddImageAuth)))
+	mux.HandleFunc("PUT /api/recipes/{id}/images", authenticator.JWTMiddleware(recipesHandler.ReorderImagesAuth))
+	mux.HandleFunc("PATCH /api/recipes/{id}/images/{imageId}", authenticator.JWTMiddleware(recipesHandler.UpdateImageAuth))
+	mux.HandleFunc("DELETE /api/recipes/{id}/images/{imageId}", authenticator.JWTMiddleware(recipesHandler.DeleteImageAuth))
+	mux.HandleFunc("/api/recipes/post", limiter.Limit("recipes-post", middleware.Limit{Burst: 20, Per: time.Hour}, authenticator.OptionalJWTMiddleware(recipesHandler.PostRecipe)))
 
 	// Comments routes
 	mux.HandleFunc("/api/comments/", commentHandler.CommentsHandler)
-	mux.HandleFunc("/api/comments/post/", middleware.JWTMiddleware(commentHandler.PostComment))
+	mux.HandleFunc("/api/comments/post/", limiter.Limit("comments-post", middleware.Limit{Burst: 10, Per: time.Minute}, authenticator.JWTMiddleware(commentHandler.PostComment)))
+
+	// Moderation routes
+	mux.HandleFunc("POST /api/reports", limiter.Limit("reports", middleware.Limit{Burst: 20, Per: time.Hour}, authenticator.JWTMiddleware(moderationHandler.ReportContent)))
+	mux.HandleFunc("GET /api/moderation/reports", authenticator.RoleMiddleware(utils.RoleModerator, moderationHandler.ReportsQueue))
+	mux.HandleFunc("POST /api/moderation/action/", authenticator.RoleMiddleware(utils.RoleModerator, moderationHandler.TakeAction))
+	mux.HandleFunc("GET /api/moderation/audit", authenticator.RoleMiddleware(utils.RoleModerator, moderationHandler.AuditLog))
+
+	// Notifications routes
+	mux.HandleFunc("GET /api/notifications", authenticator.JWTMiddleware(notificationHandler.GetNotificationsAuth))
+	mux.HandleFunc("POST /api/notifications/read", authenticator.JWTMiddleware(notificationHandler.MarkAllReadAuth))
+	mux.HandleFunc("POST /api/notifications/{id}/read", authenticator.JWTMiddleware(notificationHandler.MarkReadAuth))
+
+	// Realtime routes
+	mux.HandleFunc("GET /api/stream", middleware.TokenFromQuery(authenticator.OptionalJWTMiddleware(streamHandler.Stream)))
+
+	// Uploaded images, when they are stored on this server
+	if local, ok := images.(*lib.LocalStore); ok {
+		mux.Handle("GET /media/", http.StripPrefix("/media/", local.Handler()))
+	}
 
 	// Meals routes
-	mux.HandleFunc("/api/mealtypes", meals.MealsHandler)
+	mealHandler := meals.NewMealHandler(tracedDB)
+	mux.HandleFunc("/api/mealtypes", mealHandler.MealsHandler)
+
+	// Background jobs
+	runner := jobs.NewRunner()
+	runner.Add(jobs.Job{Name: "data-exports", Interval: 30 * time.Second, Run: userHandler.ProcessExports})
+	runner.Add(jobs.Job{Name: "account-purge", Interval: time.Hour, Run: userHandler.PurgeDeletedAccounts})
+	runner.Add(jobs.Job{Name: "login-failures-purge", Interval: time.Hour, Run: authHandler.PurgeLoginFailures})
+	collector := assets.NewCollector(tracedDB, tracing.NewStore(images))
+	collector.Grace = cfg.Assets.GCGrace
+	collector.DryRun = cfg.Assets.GCDryRun
+	runner.Add(jobs.Job{Name: "asset-cleanup", Interval: time.Hour, Run: collector.Collect})
+
+	// Stopped by SIGINT (Ctrl+C) or SIGTERM (docker stop)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runner.Start(ctx)
+
+	trustedProxies, err := cfg.Server.TrustedProxyPrefixes()
+	if err != nil {
+		slog.Error("Invalid trusted proxies", "err", err)
+		os.Exit(1)
+	}
+
+	// CORS, inside the request log, metrics and tracing so preflights are counted too. RealIP
+	// goes first so all of them see the client's address
+	handlerWithCORS := middleware.RealIP(trustedProxies)(middleware.RequestLogging(middleware.RequestMetrics(middleware.Tracing(middleware.CorsMiddleware(mux)))))
+
+	server := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           handlerWithCORS,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    1 << 20,
+	}
+	// Open event streams would hold Shutdown until its timeout, end them right away
+	server.RegisterOnShutdown(hub.Close)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLS() {
+			slog.Info("Server running", "addr", cfg.Server.Addr, "tls", true)
+			serveErr <- server.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		} else {
+			slog.Info("Server running", "addr", cfg.Server.Addr, "tls", false)
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		fatal("Server error", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process
+
+	slog.Info("Shutting down, waiting for requests in flight")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Shutdown error", "err", err)
+	}
+
+	// The jobs saw ctx end with the signal, they stop after their current run
+	runner.Wait()
+	// Mail queued by requests that already got their answer
+	authHandler.Wait()
 
-	// CORS
-	handlerWithCORS := middleware.CorsMiddleware(mux)
+	if err := db.DB.Close(); err != nil {
+		slog.Error("DB close error", "err", err)
+	}
+	// Flush the spans of the last requests
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Tracing shutdown error", "err", err)
+	}
+	slog.Info("Server stopped")
+}
 
-	log.Println("Server running at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", handlerWithCORS))
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
@@ -0,0 +1,100 @@
+// Package metrics holds the Prometheus metrics of the server, served on /metrics.
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bitebox"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	ImageUploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_upload_duration_seconds",
+		Help:      "Time to process an uploaded image and store all of its variants.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	})
+
+	// ImageUploadFailures is labelled rejected for invalid images, storage for backend errors
+	// and error for the rest, such as a broken upload stream
+	ImageUploadFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_upload_failures_total",
+		Help:      "Image uploads that failed, by reason.",
+	}, []string{"reason"})
+
+	RecipesCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recipes_created_total",
+		Help:      "Recipes posted.",
+	})
+
+	CommentsPosted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "comments_posted_total",
+		Help:      "Comments and ratings posted.",
+	})
+
+	// Logins is labelled succeeded, failed or locked_out
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Password logins by result.",
+	}, []string{"result"})
+)
+
+// Login results
+const (
+	LoginSucceeded = "succeeded"
+	LoginFailed    = "failed"
+	LoginLockedOut = "locked_out"
+)
+
+// RegisterDB exports the connection pool stats of db
+func RegisterDB(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveRequest records a served request, route is the mux pattern that matched
+func ObserveRequest(route, method string, status int, elapsed time.Duration) {
+	HTTPRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	HTTPDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+}
+
+// Handler serves the metrics. With a token, scrapers have to send it as a bearer token.
+func Handler(token string) http.Handler {
+	metrics := promhttp.Handler()
+	if token == "" {
+		return metrics
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveRequest(t *testing.T) {
+	before := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET /api/recipes/{id}", "GET", "404"))
+
+	ObserveRequest("GET /api/recipes/{id}", "GET", http.StatusNotFound, 20*time.Millisecond)
+
+	if got := testutil.ToFloat64(HTTPRequests.WithLabelValues("GET /api/recipes/{id}", "GET", "404")); got != before+1 {
+		t.Errorf("Expected the request counted, got %v", got)
+	}
+}
+
+func TestHandler_Token(t *testing.T) {
+	RecipesCreated.Inc()
+	handler := Handler("scrape-secret")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the token, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape-secret")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "bitebox_recipes_created_total") {
+		t.Errorf("Expected the metrics, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,49 +1,96 @@
 package middleware
 
 import (
-	"fmt"
-	"log"
+	"database/sql"
 	"net/http"
-	"os"
-	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/joho/godotenv"
+	"github.com/Zheng5005/BiteBox/db"
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
 )
 
-func JWTMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	errENV := godotenv.Load()
-	if errENV != nil {
-		log.Println("No .env file available")
-	}
+// Authenticator checks access tokens against the sessions table, so a token
+// stops working as soon as its session is revoked
+type Authenticator struct {
+	DB        db.DBExecutor
+	SecretKey string
+}
+
+func NewAuthenticator(db db.DBExecutor, secret string) *Authenticator {
+	return &Authenticator{DB: db, SecretKey: secret}
+}
 
+func (a *Authenticator) JWTMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") {
-			http.Error(w, "Missing token", http.StatusUnauthorized)
-			return
+		if a.checkSession(w, r) {
+			next(w, r)
 		}
+	}
+}
 
-		tokenStr := strings.TrimPrefix(auth, "Bearer ")
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Invalid method")
-			}
-			return []byte(getEnv("SECRET_KEY", "other_key")), nil
-		})
-
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
+// OptionalJWTMiddleware lets anonymous requests through, a token that is sent
+// still has to belong to a live session
+func (a *Authenticator) OptionalJWTMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" || a.checkSession(w, r) {
+			next(w, r)
 		}
+	}
+}
 
+// TokenFromQuery accepts the access token as ?access_token= for clients that can't set
+// headers, like the browser's EventSource. Only for routes that need it, URLs end up in logs.
+func TokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
 		next(w, r)
 	}
 }
 
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// checkSession writes the error response and returns false when the request's session is not valid
+func (a *Authenticator) checkSession(w http.ResponseWriter, r *http.Request) bool {
+	claims, err := utils.ParseClaims(r, a.SecretKey)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return false
 	}
-	return fallback
+
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return false
+	}
+
+	var stale bool
+	err = a.DB.QueryRowContext(r.Context(),
+		"SELECT last_seen_at < NOW() - interval '1 minute' FROM sessions WHERE id = $1 AND revoked_at IS NULL",
+		sessionID,
+	).Scan(&stale)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Session revoked", http.StatusUnauthorized)
+		return false
+	} else if err != nil {
+		logging.FromContext(r.Context()).Error("DB error", "err", err)
+		http.Error(w, "Error validating session", http.StatusInternalServerError)
+		return false
+	}
+
+	// Last seen data is kept to the minute, so most requests don't write
+	if stale {
+		_, err = a.DB.ExecContext(r.Context(),
+			"UPDATE sessions SET last_seen_at = NOW(), ip = $2 WHERE id = $1 AND last_seen_at < NOW() - interval '1 minute'",
+			sessionID, utils.ClientIP(r),
+		)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("DB error", "err", err)
+		}
+	}
+
+	if userID, ok := claims["user_id"].(string); ok {
+		logging.SetUserID(r.Context(), userID)
+	}
+
+	return true
 }
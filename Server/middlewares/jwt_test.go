@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func sessionRequest(t *testing.T) *http.Request {
+	token, err := utils.GenerateMockSessionJWT("5", "sess-1", "other_key")
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestJWTMiddleware_RecentSessionIsNotWritten(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM sessions WHERE id = $1 AND revoked_at IS NULL")).
+		WithArgs("sess-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stale"}).AddRow(false))
+
+	called := false
+	handler := NewAuthenticator(db, "other_key").JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	handler(httptest.NewRecorder(), sessionRequest(t))
+
+	if !called {
+		t.Errorf("Expected the request to reach the handler")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestJWTMiddleware_StaleSessionIsTouched(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM sessions WHERE id = $1 AND revoked_at IS NULL")).
+		WithArgs("sess-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stale"}).AddRow(true))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET last_seen_at = NOW(), ip = $2 WHERE id = $1 AND last_seen_at < NOW() - interval '1 minute'")).
+		WithArgs("sess-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	called := false
+	handler := NewAuthenticator(db, "other_key").JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	handler(httptest.NewRecorder(), sessionRequest(t))
+
+	if !called {
+		t.Errorf("Expected the request to reach the handler")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestJWTMiddleware_RevokedSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM sessions WHERE id = $1 AND revoked_at IS NULL")).
+		WithArgs("sess-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stale"}))
+
+	handler := NewAuthenticator(db, "other_key").JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Revoked session reached the handler")
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, sessionRequest(t))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 Unauthorized, got %d", rr.Code)
+	}
+}
+
+func TestOptionalJWTMiddleware_AnonymousPasses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	called := false
+	handler := NewAuthenticator(db, "other_key").OptionalJWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/recipes/post", nil))
+
+	if !called {
+		t.Errorf("Expected the anonymous request to reach the handler")
+	}
+
+	// No session lookup without a token
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestOptionalJWTMiddleware_RevokedSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM sessions WHERE id = $1 AND revoked_at IS NULL")).
+		WithArgs("sess-1").
+		WillReturnRows(sqlmock.NewRows([]string{"stale"}))
+
+	handler := NewAuthenticator(db, "other_key").OptionalJWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Revoked session reached the handler")
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, sessionRequest(t))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 Unauthorized, got %d", rr.Code)
+	}
+}
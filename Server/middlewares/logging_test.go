@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Zheng5005/BiteBox/logging"
+)
+
+// captureLogs sends the default logger to a buffer for the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestRequestLogging_AccessLog(t *testing.T) {
+	logs := captureLogs(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/recipes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.SetUserID(r.Context(), "5")
+		logging.FromContext(r.Context()).Info("from the handler")
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+	})
+
+	rr := httptest.NewRecorder()
+	RequestLogging(mux).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/recipes/42", nil))
+
+	requestID := rr.Header().Get(RequestIDHeader)
+	if len(requestID) != 32 {
+		t.Fatalf("Expected a generated request ID, got %q", requestID)
+	}
+
+	var lines []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("Log line is not JSON: %s", line)
+		}
+		lines = append(lines, entry)
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("Expected the handler's line and the access line, got %d", len(lines))
+	}
+
+	for _, entry := range lines {
+		if entry["request_id"] != requestID {
+			t.Errorf("Expected every line tagged with the request ID, got %v", entry)
+		}
+	}
+
+	access := lines[1]
+	if access["route"] != "GET /api/recipes/{id}" || access["status"] != float64(404) || access["user_id"] != "5" {
+		t.Errorf("Unexpected access log %v", access)
+	}
+}
+
+func TestRequestLogging_KeepsUpstreamID(t *testing.T) {
+	captureLogs(t)
+
+	handler := RequestLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := map[string]bool{
+		"req-123.abc":           true,
+		"bad id\nwith newlines": false,
+	}
+	for id, kept := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.Header.Set(RequestIDHeader, id)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get(RequestIDHeader); (got == id) != kept {
+			t.Errorf("ID %q: kept=%v, response ID %q", id, kept, got)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/metrics"
+)
+
+// RequestMetrics counts requests and their latency by route pattern, not path,
+// so recipe IDs and the like don't each get their own series
+func RequestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveRequest(route, r.Method, rec.statusCode(), time.Since(start))
+	})
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled evenly over Per
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// RateLimitResult is the state of a bucket after a request took from it
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, zero when allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the buckets. Take must be atomic per key, shared backends
+// (Redis, Postgres, ...) can implement it so limits hold across instances.
+type RateLimitStore interface {
+	Take(key string, limit Limit, now time.Time) (RateLimitResult, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	per     time.Duration
+}
+
+// MemoryStore keeps buckets in process, limits are per instance
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	burst := float64(limit.Burst)
+	rate := burst / limit.Per.Seconds()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		s.buckets[key] = b
+	}
+	b.per = limit.Per
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	result := RateLimitResult{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((burst - b.tokens) / rate)
+	return result, nil
+}
+
+// sweep drops buckets that refilled completely, at most once a minute
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > b.per {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// RateLimiter applies per route limits, keyed by user for authenticated requests and by IP otherwise
+type RateLimiter struct {
+	Store     RateLimitStore
+	SecretKey string
+}
+
+func NewRateLimiter(store RateLimitStore, secret string) *RateLimiter {
+	return &RateLimiter{Store: store, SecretKey: secret}
+}
+
+// Limit wraps next with its own bucket per client, name keeps the buckets of different routes apart
+func (l *RateLimiter) Limit(name string, limit Limit, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := name + ":ip:" + utils.ClientIP(r)
+		if userID, err := utils.ParseToken(r, l.SecretKey); err == nil {
+			key = name + ":user:" + userID
+		}
+
+		result, err := l.Store.Take(key, limit, time.Now())
+		if err != nil {
+			// A broken store shouldn't take the API down with it
+			logging.FromContext(r.Context()).Error("Rate limit store error", "err", err)
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Burst, ceilSeconds(limit.Per)))
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
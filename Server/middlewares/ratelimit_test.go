@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestMemoryStore_RefillsOverTime(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Burst: 2, Per: time.Minute}
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < 2; i++ {
+		if res, _ := store.Take("k", limit, now); !res.Allowed {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+	}
+
+	res, _ := store.Take("k", limit, now)
+	if res.Allowed || res.RetryAfter != 30*time.Second {
+		t.Fatalf("Expected third request to wait 30s, got %+v", res)
+	}
+
+	if res, _ := store.Take("k", limit, now.Add(30*time.Second)); !res.Allowed {
+		t.Errorf("Expected a token to be back after 30s")
+	}
+
+	if res, _ := store.Take("other", limit, now); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("Expected keys to have separate buckets, got %+v", res)
+	}
+}
+
+func TestRateLimiter_Headers(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryStore(), "other_key")
+	handler := limiter.Limit("login", Limit{Burst: 1, Per: time.Minute}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil))
+
+	if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "1" || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("Expected first request through with headers, got %d %v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/api/auth/login", nil))
+
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected 429 with Retry-After 60, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	// Authenticated requests get their own bucket instead of sharing the IP's
+	token, _ := utils.GenerateMockJWT("5", "other_key")
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected user bucket to be separate, got %d", rr.Code)
+	}
+}
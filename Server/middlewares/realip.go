@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+// RealIP works out the client address once per request, for utils.ClientIP. X-Forwarded-For is
+// only read when the connection comes from one of the trusted proxies, and then from the right:
+// the first address that isn't a trusted proxy is the client, whatever is left of it could be forged.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := utils.RemoteIP(r)
+			if isTrusted(trusted, ip) {
+				ip = forwardedFor(trusted, r, ip)
+			}
+			next.ServeHTTP(w, r.WithContext(utils.WithClientIP(r.Context(), ip)))
+		})
+	}
+}
+
+func forwardedFor(trusted []netip.Prefix, r *http.Request, remote string) string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		client = hops[i]
+		if !isTrusted(trusted, client) {
+			break
+		}
+	}
+	return client
+}
+
+func isTrusted(trusted []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
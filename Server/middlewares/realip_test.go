@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct client", "203.0.113.9:4321", "", "203.0.113.9"},
+		{"untrusted peer can't forge", "203.0.113.9:4321", "198.51.100.1", "203.0.113.9"},
+		{"trusted proxy", "10.0.0.2:4321", "198.51.100.1", "198.51.100.1"},
+		{"chain of proxies", "10.0.0.2:4321", "198.51.100.1, 10.0.0.3", "198.51.100.1"},
+		{"spoofed left of the client", "10.0.0.2:4321", "192.0.2.66, 198.51.100.1", "198.51.100.1"},
+		{"proxy without the header", "10.0.0.2:4321", "", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = utils.ClientIP(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/recipes", nil)
+			req.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Zheng5005/BiteBox/utils"
+)
+
+// RoleMiddleware only lets through requests whose token carries at least the required role.
+// Roles are read from the JWT, so a role change takes effect on the user's next token refresh.
+func (a *Authenticator) RoleMiddleware(role string, next http.HandlerFunc) http.HandlerFunc {
+	return a.JWTMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := utils.ParseClaims(r, a.SecretKey)
+		if err != nil {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		if !utils.HasRole(utils.ClaimsRole(claims), role) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	})
+}
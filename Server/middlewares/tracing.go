@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Zheng5005/BiteBox/logging"
+	"github.com/Zheng5005/BiteBox/tracing"
+	"github.com/Zheng5005/BiteBox/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a server span for each request, continuing the trace of a caller that sent
+// a traceparent header. The request's logger is tagged with the trace so log lines and spans
+// can be matched up. It goes inside RequestLogging, which it tells the trace ID.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(utils.ClientIP(r)),
+			),
+		)
+		defer span.End()
+
+		if sc := span.SpanContext(); sc.IsValid() {
+			logger := logging.FromContext(ctx).With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+			ctx = logging.WithLogger(ctx, logger)
+			logging.SetTraceID(ctx, sc.TraceID().String())
+		}
+
+		traced := r.WithContext(ctx)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, traced)
+
+		// The mux set the pattern on our copy, hand it back to the access log and metrics
+		r.Pattern = traced.Pattern
+		if r.Pattern != "" {
+			// Patterns registered without a method still get one in the span name
+			route := r.Pattern
+			if method, path, ok := strings.Cut(r.Pattern, " "); ok {
+				route = path
+				span.SetName(method + " " + path)
+			} else {
+				span.SetName(r.Method + " " + route)
+			}
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.statusCode()))
+		if rec.statusCode() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.statusCode()))
+		}
+	})
+}
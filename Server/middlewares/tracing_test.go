@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Zheng5005/BiteBox/db"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+func TestTracing_RequestAndQuerySpans(t *testing.T) {
+	spans := recordSpans(t)
+	logs := captureLogs(t)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open mock db: %v", err)
+	}
+	defer mockDB.Close()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name_recipe FROM recipes WHERE id = $1")).
+		WithArgs("42").
+		WillReturnRows(sqlmock.NewRows([]string{"name_recipe"}).AddRow("Soup"))
+
+	traced := db.Traced(mockDB)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/recipes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var name string
+		if err := traced.QueryRowContext(r.Context(), "SELECT name_recipe FROM recipes WHERE id = $1", r.PathValue("id")).Scan(&name); err != nil {
+			t.Errorf("Query failed: %v", err)
+		}
+		w.Write([]byte(name))
+	})
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/api/recipes/42", nil)
+	req.Header.Set("traceparent", parent)
+	rr := httptest.NewRecorder()
+
+	RequestLogging(Tracing(mux)).ServeHTTP(rr, req)
+
+	ended := spans.GetSpans()
+	if len(ended) != 2 {
+		t.Fatalf("Expected a query and a request span, got %d", len(ended))
+	}
+	query, server := ended[0], ended[1]
+
+	if server.Name != "GET /api/recipes/{id}" || server.SpanKind != trace.SpanKindServer {
+		t.Errorf("Unexpected request span %q of kind %v", server.Name, server.SpanKind)
+	}
+	if server.Parent.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the caller's trace to continue, got %s", server.SpanContext.TraceID())
+	}
+	if query.Name != "SELECT" || query.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("Expected the query as a child of the request, got %q under %s", query.Name, query.Parent.SpanID())
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("Invalid access log %q: %v", logs.String(), err)
+	}
+	if line["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || line["route"] != "GET /api/recipes/{id}" {
+		t.Errorf("Expected the trace and route in the access log, got %v", line)
+	}
+}
+
+func TestTracing_ServerErrorMarksSpan(t *testing.T) {
+	spans := recordSpans(t)
+
+	handler := Tracing(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Query error", http.StatusInternalServerError)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nope", nil))
+
+	ended := spans.GetSpans()
+	if len(ended) != 1 {
+		t.Fatalf("Expected one span, got %d", len(ended))
+	}
+	if ended[0].Status.Code.String() != "Error" || ended[0].Name != "GET" {
+		t.Errorf("Expected an unnamed failed span, got %q with status %v", ended[0].Name, ended[0].Status)
+	}
+}
@@ -0,0 +1,134 @@
+// Package realtime is an in-process pub/sub hub for pushing updates to connected clients.
+// Messages are numbered so a client that reconnects can ask for what it missed.
+package realtime
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// ClientBuffer is how many messages a client can fall behind before it is dropped
+	ClientBuffer = 32
+	// HistorySize is how many recent messages are kept for clients resuming with Last-Event-ID
+	HistorySize = 512
+)
+
+type Message struct {
+	ID    uint64
+	Topic string
+	Event string
+	Data  []byte
+}
+
+// Client receives the messages of its topics on C. The hub closes C when the client
+// is unsubscribed or too slow to keep up, a dropped client reconnects and resumes.
+type Client struct {
+	C      <-chan Message
+	ch     chan Message
+	topics map[string]bool
+}
+
+type Hub struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []Message
+	clients map[*Client]bool
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: map[*Client]bool{}}
+}
+
+// RecipeTopic carries new comments and ratings of a recipe
+func RecipeTopic(recipeID string) string {
+	return fmt.Sprintf("recipe:%s", recipeID)
+}
+
+// UserTopic carries the notifications of a user
+func UserTopic(userID string) string {
+	return fmt.Sprintf("user:%s", userID)
+}
+
+// Publish numbers the message and hands it to every client of the topic without blocking
+func (h *Hub) Publish(topic, event string, data []byte) Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	msg := Message{ID: h.lastID, Topic: topic, Event: event, Data: data}
+
+	h.history = append(h.history, msg)
+	if len(h.history) > HistorySize {
+		h.history = h.history[len(h.history)-HistorySize:]
+	}
+
+	for c := range h.clients {
+		if !c.topics[topic] {
+			continue
+		}
+		select {
+		case c.ch <- msg:
+		default:
+			h.drop(c)
+		}
+	}
+
+	return msg
+}
+
+// Subscribe registers a client for the topics. With a lastID it also returns the messages
+// published after it; complete is false when some of them are no longer kept, or lastID
+// comes from before a restart, and the client has to reload instead.
+func (h *Hub) Subscribe(topics []string, lastID uint64) (c *Client, replay []Message, complete bool) {
+	ch := make(chan Message, ClientBuffer)
+	c = &Client{C: ch, ch: ch, topics: map[string]bool{}}
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	complete = true
+	if lastID > 0 {
+		switch {
+		case lastID > h.lastID:
+			complete = false
+		case len(h.history) > 0 && h.history[0].ID > lastID+1:
+			complete = false
+		}
+
+		for _, msg := range h.history {
+			if msg.ID > lastID && c.topics[msg.Topic] {
+				replay = append(replay, msg)
+			}
+		}
+	}
+
+	h.clients[c] = true
+	return c, replay, complete
+}
+
+func (h *Hub) Unsubscribe(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(c)
+}
+
+// Close drops every client, ending their streams so a shutting down server is not held open by them
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		h.drop(c)
+	}
+}
+
+// drop must be called with the lock held
+func (h *Hub) drop(c *Client) {
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c.ch)
+	}
+}
@@ -0,0 +1,77 @@
+package realtime
+
+import "testing"
+
+func TestHub_DeliversOnlySubscribedTopics(t *testing.T) {
+	hub := NewHub()
+	c, _, _ := hub.Subscribe([]string{RecipeTopic("1")}, 0)
+	defer hub.Unsubscribe(c)
+
+	hub.Publish(RecipeTopic("2"), "comment", []byte("other"))
+	hub.Publish(RecipeTopic("1"), "comment", []byte("mine"))
+
+	msg := <-c.C
+	if string(msg.Data) != "mine" || msg.ID != 2 {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+
+	if len(c.C) != 0 {
+		t.Errorf("expected no more messages, got %d", len(c.C))
+	}
+}
+
+func TestHub_ReplaysAfterLastID(t *testing.T) {
+	hub := NewHub()
+	hub.Publish(UserTopic("5"), "notification", []byte("1"))
+	hub.Publish(UserTopic("6"), "notification", []byte("2"))
+	hub.Publish(UserTopic("5"), "notification", []byte("3"))
+
+	c, replay, complete := hub.Subscribe([]string{UserTopic("5")}, 1)
+	defer hub.Unsubscribe(c)
+
+	if !complete {
+		t.Error("expected a complete replay")
+	}
+
+	if len(replay) != 1 || replay[0].ID != 3 {
+		t.Errorf("unexpected replay: %+v", replay)
+	}
+}
+
+func TestHub_IncompleteReplay(t *testing.T) {
+	hub := NewHub()
+	for range HistorySize + 10 {
+		hub.Publish(UserTopic("5"), "notification", nil)
+	}
+
+	// Older than anything still kept
+	if _, _, complete := hub.Subscribe([]string{UserTopic("5")}, 3); complete {
+		t.Error("expected an incomplete replay for a trimmed history")
+	}
+
+	// Newer than anything published, the server restarted since
+	if _, _, complete := hub.Subscribe([]string{UserTopic("5")}, 100000); complete {
+		t.Error("expected an incomplete replay for an unknown id")
+	}
+}
+
+func TestHub_DropsSlowClient(t *testing.T) {
+	hub := NewHub()
+	c, _, _ := hub.Subscribe([]string{RecipeTopic("1")}, 0)
+
+	for range ClientBuffer + 1 {
+		hub.Publish(RecipeTopic("1"), "comment", nil)
+	}
+
+	received := 0
+	for range c.C {
+		received++
+	}
+
+	if received != ClientBuffer {
+		t.Errorf("expected %d buffered messages before the drop, got %d", ClientBuffer, received)
+	}
+
+	// Unsubscribing a dropped client is harmless
+	hub.Unsubscribe(c)
+}
@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/Zheng5005/BiteBox/lib"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Store records a client span for every call to the image store it wraps
+type Store struct {
+	lib.ImageStore
+	// Backend names the store on the spans, cloudinary, local or s3
+	Backend string
+}
+
+func NewStore(store lib.ImageStore) *Store {
+	backend := "other"
+	switch store.(type) {
+	case *lib.CloudinaryStore:
+		backend = "cloudinary"
+	case *lib.LocalStore:
+		backend = "local"
+	case *lib.S3Store:
+		backend = "s3"
+	}
+	return &Store{ImageStore: store, Backend: backend}
+}
+
+func (s *Store) Save(ctx context.Context, file io.Reader, filename string) (string, error) {
+	ctx, span := Tracer().Start(ctx, "image_store.save", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("image_store.backend", s.Backend), attribute.String("image_store.filename", filename)))
+	defer span.End()
+
+	url, err := s.ImageStore.Save(ctx, file, filename)
+	RecordError(span, err)
+	return url, err
+}
+
+func (s *Store) Delete(ctx context.Context, url string) error {
+	ctx, span := Tracer().Start(ctx, "image_store.delete", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("image_store.backend", s.Backend), attribute.String("image_store.url", url)))
+	defer span.End()
+
+	err := s.ImageStore.Delete(ctx, url)
+	RecordError(span, err)
+	return err
+}
+
+// Ping passes readiness checks through to stores that support them
+func (s *Store) Ping(ctx context.Context) error {
+	if pinger, ok := s.ImageStore.(lib.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// RecordError marks the span failed, a nil error leaves it unset
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Zheng5005/BiteBox/config"
+	"github.com/Zheng5005/BiteBox/lib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup_Exporters(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	for _, exporter := range []string{"none", "stdout"} {
+		shutdown, err := Setup(context.Background(), config.Tracing{Exporter: exporter, ServiceName: "bitebox", SampleRatio: 1})
+		if err != nil {
+			t.Fatalf("Setup with %s failed: %v", exporter, err)
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("Shutdown with %s failed: %v", exporter, err)
+		}
+	}
+
+	if _, err := Setup(context.Background(), config.Tracing{Exporter: "jaeger"}); err == nil {
+		t.Error("Expected an unknown exporter to fail")
+	}
+}
+
+func TestStore_SpansForEachCall(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	local, err := lib.NewLocalStore(t.TempDir(), "http://localhost:8080/media")
+	if err != nil {
+		t.Fatalf("Failed to create image store: %v", err)
+	}
+	store := NewStore(local)
+
+	url, err := store.Save(context.Background(), strings.NewReader("not really a png"), "soup.png")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := store.Delete(context.Background(), "http://elsewhere.example/soup.png"); err == nil {
+		t.Fatalf("Expected a foreign URL to be refused, %s was saved", url)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "image_store.save" || spans[1].Name != "image_store.delete" {
+		t.Fatalf("Expected a save and a delete span, got %v", spans.Snapshots())
+	}
+	if spans[0].Status.Code != codes.Unset || spans[1].Status.Code != codes.Error {
+		t.Errorf("Expected only the delete marked failed, got %v and %v", spans[0].Status, spans[1].Status)
+	}
+}
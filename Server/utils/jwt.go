@@ -10,9 +10,24 @@ import (
 )
 
 func ParseToken(r *http.Request, secret string) (string, error)  {
+	claims, err := ParseClaims(r, secret)
+	if err != nil {
+		return "", err
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return "", fmt.Errorf("user_id missing")
+	}
+
+	return userID, nil
+}
+
+// ParseClaims validates the Bearer token of the request and returns its claims
+func ParseClaims(r *http.Request, secret string) (jwt.MapClaims, error) {
 	auth := r.Header.Get("Authorization")
 	if !strings.HasPrefix(auth, "Bearer ") {
-		return "", fmt.Errorf("Mssing token")
+		return nil, fmt.Errorf("Mssing token")
 	}
 
 	tokenStr := strings.TrimPrefix(auth, "Bearer ")
@@ -24,20 +39,24 @@ func ParseToken(r *http.Request, secret string) (string, error)  {
 	})
 
 	if err != nil || !token.Valid {
-		return "", fmt.Errorf("Invalid token")
+		return nil, fmt.Errorf("Invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", fmt.Errorf("Invalid claims")
+		return nil, fmt.Errorf("Invalid claims")
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return "", fmt.Errorf("user_id missing")
-	}
+	return claims, nil
+}
 
-	return userID, nil
+// ClaimsRole returns the role carried by the token, tokens issued before roles existed count as RoleUser
+func ClaimsRole(claims jwt.MapClaims) string {
+	role, ok := claims["role"].(string)
+	if !ok || role == "" {
+		return RoleUser
+	}
+	return role
 }
 
 func GenerateMockJWT(userID, secret string) (string, error)  {
@@ -48,3 +67,23 @@ func GenerateMockJWT(userID, secret string) (string, error)  {
 
 	return token.SignedString([]byte(secret))
 }
+
+func GenerateMockJWTWithRole(userID, role, secret string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"role": role,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	return token.SignedString([]byte(secret))
+}
+
+func GenerateMockSessionJWT(userID, sessionID, secret string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"sid": sessionID,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	return token.SignedString([]byte(secret))
+}
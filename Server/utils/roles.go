@@ -0,0 +1,29 @@
+package utils
+
+// Roles a user can hold, each one includes the permissions of the ones before it
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the permissions of required
+func HasRole(role, required string) bool {
+	have, ok := roleRank[role]
+	if !ok {
+		return false
+	}
+	return have >= roleRank[required]
+}
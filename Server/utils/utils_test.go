@@ -3,6 +3,7 @@ package utils
 import (
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestParseToken_success(t *testing.T) {
@@ -46,3 +47,137 @@ func TestParseToken_wrongSecret(t *testing.T) {
 		t.Fatal("expected error for wrong secret")
 	}
 }
+
+func TestParseClaims_role(t *testing.T) {
+	tokenStr, _ := GenerateMockJWTWithRole("user-1", RoleModerator, "secret")
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+tokenStr)
+
+	claims, err := ParseClaims(r, "secret")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if role := ClaimsRole(claims); role != RoleModerator {
+		t.Errorf("expected role %q, got %q", RoleModerator, role)
+	}
+}
+
+func TestClaimsRole_defaultsToUser(t *testing.T) {
+	tokenStr, _ := GenerateMockJWT("user-1", "secret")
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+tokenStr)
+
+	claims, err := ParseClaims(r, "secret")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if role := ClaimsRole(claims); role != RoleUser {
+		t.Errorf("expected role %q, got %q", RoleUser, role)
+	}
+}
+
+func TestHasRole(t *testing.T) {
+	cases := []struct {
+		role, required string
+		want           bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleModerator, RoleModerator, true},
+		{RoleUser, RoleModerator, false},
+		{"superuser", RoleUser, false},
+	}
+
+	for _, c := range cases {
+		if got := HasRole(c.role, c.required); got != c.want {
+			t.Errorf("HasRole(%q, %q) = %v, want %v", c.role, c.required, got, c.want)
+		}
+	}
+}
+
+func TestNewRandomToken_unique(t *testing.T) {
+	a, err := NewRandomToken(32)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	b, _ := NewRandomToken(32)
+
+	if a == b {
+		t.Error("expected two random tokens to differ")
+	}
+}
+
+func TestHashToken_deterministic(t *testing.T) {
+	if HashToken("abc") != HashToken("abc") {
+		t.Error("expected the same token to hash to the same value")
+	}
+	if HashToken("abc") == HashToken("abd") {
+		t.Error("expected different tokens to hash to different values")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.7:51234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+
+	if ip := ClientIP(r); ip != "10.0.0.7" {
+		t.Errorf("expected remote address 10.0.0.7, got %q", ip)
+	}
+
+	r = r.WithContext(WithClientIP(r.Context(), "203.0.113.9"))
+
+	if ip := ClientIP(r); ip != "203.0.113.9" {
+		t.Errorf("expected recorded address 203.0.113.9, got %q", ip)
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+	if got := DeviceName(ua); got != "Firefox on Windows" {
+		t.Errorf("expected 'Firefox on Windows', got %q", got)
+	}
+
+	if got := DeviceName("curl/8.5.0"); got != "Unknown device" {
+		t.Errorf("expected 'Unknown device', got %q", got)
+	}
+}
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// Test vectors from RFC 6238 appendix B, truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		2000000000: "279037",
+	}
+
+	for unix, want := range cases {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("at %d expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateTOTP_window(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	previous, _ := TOTPCode(secret, TOTPStep(now)-1)
+	if step, ok := ValidateTOTP(secret, previous, now); !ok || step != TOTPStep(now)-1 {
+		t.Errorf("expected previous step code to be accepted")
+	}
+
+	old, _ := TOTPCode(secret, TOTPStep(now)-3)
+	if _, ok := ValidateTOTP(secret, old, now); ok {
+		t.Errorf("expected code three steps old to be rejected")
+	}
+}